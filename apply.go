@@ -0,0 +1,241 @@
+package fproto
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// An ApplyFunc is invoked by Apply for each element, before and/or after its
+// children are traversed.
+type ApplyFunc func(*Cursor) bool
+
+// Traverses the element tree recursively like Walk, calling pre and post for
+// each element, in the style of golang.org/x/tools/go/ast/astutil.Apply.
+//
+// If pre returns false, the children of the element are not traversed and
+// post is not called for it. If post returns false, the traversal is stopped.
+// The Cursor methods can change the element lists while traversing, and the
+// Parent links of the new elements are updated. Inserted elements are not
+// traversed. An element replaced in pre is traversed in place of the old one:
+// its children are visited and post is called with it.
+//
+// Returns the root, which may have been replaced.
+func Apply(root FProtoElement, pre, post ApplyFunc) (result FProtoElement) {
+	a := &application{pre: pre, post: post}
+	defer func() {
+		if r := recover(); r != nil && r != errAbortApply {
+			panic(r)
+		}
+		result = a.root
+	}()
+	a.root = root
+	a.root = a.apply(nil, "", nil, root)
+	return
+}
+
+var errAbortApply = new(int)
+
+// A Cursor describes an element encountered during Apply.
+type Cursor struct {
+	parent  FProtoElement
+	name    string
+	iter    *applyIterator
+	element FProtoElement
+}
+
+// Returns the current element.
+func (c *Cursor) Element() FProtoElement { return c.element }
+
+// Returns the parent of the current element, nil for the root.
+func (c *Cursor) Parent() FProtoElement { return c.parent }
+
+// Returns the name of the parent list field containing the current element,
+// like "Fields" or "Options". Empty for the root.
+func (c *Cursor) Name() string { return c.name }
+
+// Returns the index of the current element in the parent list, -1 for the root.
+func (c *Cursor) Index() int {
+	if c.iter == nil {
+		return -1
+	}
+	return c.iter.index
+}
+
+// Replaces the current element. Its Parent is set to the current parent, and
+// the Parent of its children to it.
+func (c *Cursor) Replace(element FProtoElement) {
+	if c.iter != nil {
+		list := c.list()
+		list.Index(c.iter.index).Set(c.listValue(list, element))
+		setElementParent(element, c.parent)
+	}
+	c.element = element
+}
+
+// Deletes the current element from the parent list.
+func (c *Cursor) Delete() {
+	list := c.list()
+	i := c.iter.index
+	reflect.Copy(list.Slice(i, list.Len()), list.Slice(i+1, list.Len()))
+	list.Index(list.Len() - 1).Set(reflect.Zero(list.Type().Elem()))
+	list.SetLen(list.Len() - 1)
+	c.iter.step--
+}
+
+// Inserts an element after the current one in the parent list. It is not traversed.
+func (c *Cursor) InsertAfter(element FProtoElement) {
+	c.insert(c.iter.index+1, element)
+	c.iter.step++
+}
+
+// Inserts an element before the current one in the parent list. It is not traversed.
+func (c *Cursor) InsertBefore(element FProtoElement) {
+	c.insert(c.iter.index, element)
+	c.iter.index++
+}
+
+func (c *Cursor) insert(i int, element FProtoElement) {
+	list := c.list()
+	v := c.listValue(list, element)
+	list.Set(reflect.Append(list, reflect.Zero(list.Type().Elem())))
+	reflect.Copy(list.Slice(i+1, list.Len()), list.Slice(i, list.Len()))
+	list.Index(i).Set(v)
+	setElementParent(element, c.parent)
+}
+
+func (c *Cursor) list() reflect.Value {
+	if c.iter == nil {
+		panic("fproto: the root element is not in a list")
+	}
+	return reflect.ValueOf(c.parent).Elem().FieldByName(c.name)
+}
+
+func (c *Cursor) listValue(list reflect.Value, element FProtoElement) reflect.Value {
+	v := reflect.ValueOf(element)
+	if !v.IsValid() || !v.Type().AssignableTo(list.Type().Elem()) {
+		panic(fmt.Sprintf("fproto: %T can't be in the %s list", element, c.name))
+	}
+	return v
+}
+
+type applyIterator struct {
+	index, step int
+}
+
+type application struct {
+	pre, post ApplyFunc
+	root      FProtoElement
+	cursor    Cursor
+	iter      applyIterator
+}
+
+func (a *application) apply(parent FProtoElement, name string, iter *applyIterator, element FProtoElement) FProtoElement {
+	saved := a.cursor
+	defer func() { a.cursor = saved }()
+
+	a.cursor = Cursor{parent: parent, name: name, iter: iter, element: element}
+	if a.pre != nil && !a.pre(&a.cursor) {
+		return a.cursor.element
+	}
+
+	element = a.cursor.element
+	if parent == nil {
+		a.root = element
+	}
+	for _, l := range elementChildLists(element) {
+		a.applyList(element, l)
+	}
+
+	if a.post != nil && !a.post(&a.cursor) {
+		if parent == nil {
+			a.root = a.cursor.element
+		}
+		panic(errAbortApply)
+	}
+	return a.cursor.element
+}
+
+func (a *application) applyList(parent FProtoElement, l childList) {
+	saved := a.iter
+	a.iter.index = 0
+	for a.iter.index < l.len(parent) {
+		a.iter.step = 1
+		a.apply(parent, l.name, &a.iter, l.at(parent, a.iter.index))
+		a.iter.index += a.iter.step
+	}
+	a.iter = saved
+}
+
+// Sets the parent of the element, and the element as parent of its children.
+func setElementParent(element, parent FProtoElement) {
+	eachChild(element, func(child FProtoElement) bool {
+		if child != nil && child.ParentElement() != element {
+			setElementParent(child, element)
+		}
+		return true
+	})
+
+	switch el := element.(type) {
+	case *ProtoFile:
+	case *MapFieldElement:
+		el.Parent = parent
+		// the embedded field has the same parent, and its options the map field
+		el.FieldElement.Parent = parent
+	default:
+		reflect.ValueOf(element).Elem().FieldByName("Parent").Set(reflect.ValueOf(parent))
+	}
+}
+
+// Returns a deep copy of the file, with the Parent links of the copied elements.
+// Use it to change files that are shared, like the ones of a ParseCache.
+func CloneFile(f *ProtoFile) *ProtoFile {
+	ret := cloneValue(reflect.ValueOf(f)).Interface().(*ProtoFile)
+	setElementParent(ret, nil)
+	return ret
+}
+
+// Copies the value recursively, except the Parent fields which are left nil.
+func cloneValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		if v.Kind() == reflect.Interface {
+			ret := reflect.New(v.Type()).Elem()
+			ret.Set(cloneValue(v.Elem()))
+			return ret
+		}
+		ret := reflect.New(v.Type().Elem())
+		ret.Elem().Set(cloneValue(v.Elem()))
+		return ret
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		ret := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			ret.Index(i).Set(cloneValue(v.Index(i)))
+		}
+		return ret
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		ret := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			ret.SetMapIndex(iter.Key(), cloneValue(iter.Value()))
+		}
+		return ret
+	case reflect.Struct:
+		ret := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).Name != "Parent" {
+				ret.Field(i).Set(cloneValue(v.Field(i)))
+			}
+		}
+		return ret
+	}
+	return v
+}
@@ -0,0 +1,275 @@
+package fproto
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// BufWorkspace is a set of proto modules described by buf.yaml (v1 and v2)
+// and buf.work.yaml files.
+//
+// Remote dependencies are never fetched. Dependencies that are not modules
+// of the workspace itself are listed in MissingDeps.
+type BufWorkspace struct {
+	Dir         string
+	Modules     []*BufModule
+	Deps        []string
+	MissingDeps []string
+}
+
+// BufModule is a proto module root of a buf workspace.
+type BufModule struct {
+	Name     string
+	Root     string
+	Excludes []string
+	Deps     []string
+}
+
+// buf.yaml and buf.work.yaml contents, all versions
+type bufConfig struct {
+	Version     string   `yaml:"version"`
+	Name        string   `yaml:"name"`
+	Deps        []string `yaml:"deps"`
+	Directories []string `yaml:"directories"`
+	Build       struct {
+		Roots    []string `yaml:"roots"`
+		Excludes []string `yaml:"excludes"`
+	} `yaml:"build"`
+	Modules []struct {
+		Path     string   `yaml:"path"`
+		Name     string   `yaml:"name"`
+		Excludes []string `yaml:"excludes"`
+	} `yaml:"modules"`
+}
+
+// Loads the buf workspace at dir, from either buf.work.yaml or buf.yaml.
+func LoadBufWorkspace(dir string) (*BufWorkspace, error) {
+	w := &BufWorkspace{
+		Dir: dir,
+	}
+
+	if work, err := readBufConfig(filepath.Join(dir, "buf.work.yaml")); err != nil {
+		return nil, err
+	} else if work != nil {
+		// v1 workspace, each directory is a module with an optional buf.yaml
+		if len(work.Directories) == 0 {
+			return nil, fmt.Errorf("%s: no directories", filepath.Join(dir, "buf.work.yaml"))
+		}
+		for _, d := range work.Directories {
+			mdir := filepath.Join(dir, filepath.FromSlash(d))
+			cfg, err := readBufConfig(filepath.Join(mdir, "buf.yaml"))
+			if err != nil {
+				return nil, err
+			}
+			if cfg == nil {
+				w.Modules = append(w.Modules, &BufModule{Root: mdir})
+			} else {
+				w.Modules = append(w.Modules, bufModulesV1(mdir, cfg)...)
+			}
+		}
+	} else {
+		cfg, err := readBufConfig(filepath.Join(dir, "buf.yaml"))
+		if err != nil {
+			return nil, err
+		}
+		if cfg == nil {
+			return nil, fmt.Errorf("No buf.work.yaml or buf.yaml found in '%s'", dir)
+		}
+		if cfg.Version == "v2" {
+			w.Modules = bufModulesV2(dir, cfg)
+		} else {
+			w.Modules = bufModulesV1(dir, cfg)
+		}
+	}
+
+	// dependencies not satisfied by the workspace modules
+	names := make(map[string]bool)
+	for _, m := range w.Modules {
+		if m.Name != "" {
+			names[m.Name] = true
+		}
+	}
+	seen := make(map[string]bool)
+	for _, m := range w.Modules {
+		for _, dep := range m.Deps {
+			if seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			w.Deps = append(w.Deps, dep)
+			if !names[bufDepName(dep)] {
+				w.MissingDeps = append(w.MissingDeps, dep)
+			}
+		}
+	}
+
+	return w, nil
+}
+
+// Returns an include path source searching the module roots in order.
+func (w *BufWorkspace) ImportSource() *IncludePathSource {
+	var dirs []string
+	for _, m := range w.Modules {
+		dirs = append(dirs, m.Root)
+	}
+	return NewIncludePathSource(dirs...)
+}
+
+// Returns the import paths of all the .proto files of the workspace modules,
+// without the excluded ones, sorted. Like buf, the same import path in two
+// modules is a *BufDuplicateFile error.
+func (w *BufWorkspace) Files() ([]string, error) {
+	var ret []string
+	seen := make(map[string]string)
+
+	for _, m := range w.Modules {
+		err := filepath.Walk(m.Root, func(fn string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if m.isExcluded(fn) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !strings.HasSuffix(fn, ".proto") || m.isExcluded(fn) {
+				return nil
+			}
+			rel, err := filepath.Rel(m.Root, fn)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if prev, ok := seen[rel]; ok {
+				return &BufDuplicateFile{Path: rel, Files: []string{prev, fn}}
+			}
+			seen[rel] = fn
+			ret = append(ret, rel)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// Returns a *BufMissingDeps error if any dependency is not a workspace module.
+func (w *BufWorkspace) CheckDeps() error {
+	if len(w.MissingDeps) > 0 {
+		return &BufMissingDeps{Deps: w.MissingDeps}
+	}
+	return nil
+}
+
+// Loads all the workspace files into a new FileSet. The extra sources are
+// searched after the workspace modules.
+//
+// If an import is not found and the workspace has missing dependencies, a
+// *BufMissingDeps error is returned.
+func (w *BufWorkspace) LoadFileSet(sources ...ImportSource) (*FileSet, error) {
+	files, err := w.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	fs := NewFileSet(append([]ImportSource{w.ImportSource()}, sources...)...)
+	if err := fs.Load(files...); err != nil {
+		if nf, ok := err.(*ImportNotFound); ok && len(w.MissingDeps) > 0 {
+			return nil, &BufMissingDeps{Deps: w.MissingDeps, Import: nf.Path}
+		}
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (m *BufModule) isExcluded(fn string) bool {
+	for _, ex := range m.Excludes {
+		if fn == ex || strings.HasPrefix(fn, ex+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// v1 and v1beta1 buf.yaml, excludes are relative to the module root
+func bufModulesV1(dir string, cfg *bufConfig) []*BufModule {
+	roots := cfg.Build.Roots
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+
+	var ret []*BufModule
+	for _, r := range roots {
+		m := &BufModule{
+			Name: cfg.Name,
+			Root: filepath.Join(dir, filepath.FromSlash(r)),
+			Deps: cfg.Deps,
+		}
+		for _, ex := range cfg.Build.Excludes {
+			m.Excludes = append(m.Excludes, filepath.Join(m.Root, filepath.FromSlash(ex)))
+		}
+		ret = append(ret, m)
+	}
+	return ret
+}
+
+// v2 buf.yaml, excludes are relative to the workspace root
+func bufModulesV2(dir string, cfg *bufConfig) []*BufModule {
+	if len(cfg.Modules) == 0 {
+		return []*BufModule{{Root: dir, Deps: cfg.Deps}}
+	}
+
+	var ret []*BufModule
+	for _, cm := range cfg.Modules {
+		m := &BufModule{
+			Name: cm.Name,
+			Root: filepath.Join(dir, filepath.FromSlash(cm.Path)),
+			Deps: cfg.Deps,
+		}
+		for _, ex := range cm.Excludes {
+			m.Excludes = append(m.Excludes, filepath.Join(dir, filepath.FromSlash(ex)))
+		}
+		ret = append(ret, m)
+	}
+	return ret
+}
+
+// Returns nil if the file doesn't exist.
+func readBufConfig(fn string) (*bufConfig, error) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	cfg := &bufConfig{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %s", fn, err.Error())
+	}
+	switch cfg.Version {
+	case "", "v1beta1", "v1", "v2":
+	default:
+		return nil, fmt.Errorf("%s: unsupported version '%s'", fn, cfg.Version)
+	}
+	return cfg, nil
+}
+
+// Returns the module name of a dependency, without the ":reference" suffix.
+func bufDepName(dep string) string {
+	if i := strings.LastIndex(dep, ":"); i >= 0 {
+		return dep[:i]
+	}
+	return dep
+}
@@ -0,0 +1,151 @@
+package fproto
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBufWorkspaceV1(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"buf.yaml": `
+version: v1
+name: buf.build/acme/shop
+deps:
+  - buf.build/googleapis/googleapis
+build:
+  roots:
+    - proto
+    - vendor
+  excludes:
+    - internal
+`,
+		"proto/shop/product.proto":   `syntax = "proto3"; package shop;`,
+		"proto/internal/debug.proto": `syntax = "proto3"; package internal;`,
+		"vendor/internal/lib.proto":  `syntax = "proto3"; package lib;`,
+		"proto/shop/README.md":       "not a proto file",
+	})
+
+	w, err := LoadBufWorkspace(dir)
+	if err != nil {
+		t.Fatalf("Error loading workspace: %v", err)
+	}
+	if len(w.Modules) != 2 || w.Modules[1].Root != filepath.Join(dir, "vendor") || w.Modules[0].Name != "buf.build/acme/shop" {
+		t.Fatalf("Expected one module for each root")
+	}
+
+	// v1 excludes are relative to each root
+	files, err := w.Files()
+	if err != nil {
+		t.Fatalf("Error listing files: %v", err)
+	}
+	if strings.Join(files, ",") != "shop/product.proto" {
+		t.Fatalf("Wrong files: %v", files)
+	}
+
+	if len(w.MissingDeps) != 1 || w.MissingDeps[0] != "buf.build/googleapis/googleapis" {
+		t.Fatalf("Wrong missing deps: %v", w.MissingDeps)
+	}
+	if _, ok := w.CheckDeps().(*BufMissingDeps); !ok {
+		t.Fatalf("CheckDeps should return a BufMissingDeps error")
+	}
+}
+
+func TestBufWorkspaceV2(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"buf.yaml": `
+version: v2
+modules:
+  - path: shop
+    name: buf.build/acme/shop
+    excludes:
+      - shop/legacy
+  - path: common
+    name: buf.build/acme/common
+deps:
+  - buf.build/acme/common
+  - buf.build/googleapis/googleapis:v1
+`,
+		"shop/shop/product.proto":   `syntax = "proto3"; package shop; import "common/money.proto"; import "google/api/annotations.proto";`,
+		"shop/legacy/old.proto":     `syntax = "proto3"; package legacy;`,
+		"common/common/money.proto": `syntax = "proto3"; package common;`,
+	})
+
+	w, err := LoadBufWorkspace(dir)
+	if err != nil {
+		t.Fatalf("Error loading workspace: %v", err)
+	}
+
+	// v2 excludes are relative to the workspace root
+	files, err := w.Files()
+	if err != nil {
+		t.Fatalf("Error listing files: %v", err)
+	}
+	if strings.Join(files, ",") != "common/money.proto,shop/product.proto" {
+		t.Fatalf("Wrong files: %v", files)
+	}
+
+	// workspace modules are not missing
+	if strings.Join(w.Deps, ",") != "buf.build/acme/common,buf.build/googleapis/googleapis:v1" ||
+		strings.Join(w.MissingDeps, ",") != "buf.build/googleapis/googleapis:v1" {
+		t.Fatalf("Wrong deps: %v, missing %v", w.Deps, w.MissingDeps)
+	}
+
+	// imports not found are reported with the missing deps
+	_, err = w.LoadFileSet()
+	md, ok := err.(*BufMissingDeps)
+	if !ok || md.Import != "google/api/annotations.proto" || len(md.Deps) != 1 {
+		t.Fatalf("Expected a BufMissingDeps error, got %v", err)
+	}
+}
+
+func TestBufWorkspaceWork(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"buf.work.yaml": `
+version: v1
+directories:
+  - shop
+  - common
+`,
+		"shop/buf.yaml": `
+version: v1
+name: buf.build/acme/shop
+deps:
+  - buf.build/acme/common
+`,
+		"shop/shop/product.proto":   `syntax = "proto3"; package shop; import "common/money.proto";`,
+		"common/common/money.proto": `syntax = "proto3"; package common;`,
+	})
+
+	w, err := LoadBufWorkspace(dir)
+	if err != nil {
+		t.Fatalf("Error loading workspace: %v", err)
+	}
+	if len(w.Modules) != 2 || w.Modules[1].Root != filepath.Join(dir, "common") {
+		t.Fatalf("Expected one module for each directory")
+	}
+	// the common module has no buf.yaml, so no name to satisfy the dependency
+	if len(w.MissingDeps) != 1 {
+		t.Fatalf("Wrong missing deps: %v", w.MissingDeps)
+	}
+
+	fs, err := w.LoadFileSet()
+	if err != nil {
+		t.Fatalf("Error loading files: %v", err)
+	}
+	if len(fs.Files) != 2 {
+		t.Fatalf("Expected 2 files, got %d", len(fs.Files))
+	}
+
+	// the same import path in two modules
+	writeTestFiles(t, dir, map[string]string{
+		"shop/common/money.proto": `syntax = "proto3"; package common;`,
+	})
+	_, err = w.Files()
+	if dup, ok := err.(*BufDuplicateFile); !ok || dup.Path != "common/money.proto" || len(dup.Files) != 2 {
+		t.Fatalf("Expected a BufDuplicateFile error, got %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package fproto
+
+import (
+	"sync"
+)
+
+// ParseCache keeps parsed files by import path and content hash, so
+// unchanged files are not parsed again. It is safe for concurrent use.
+//
+// Files are copied when stored and returned, so the FileSets using the cache
+// can change their files, like with Apply.
+type ParseCache struct {
+	mu      sync.Mutex
+	entries map[string]*parseCacheEntry
+	hits    int64
+	misses  int64
+}
+
+type parseCacheEntry struct {
+	hash string
+	file *ProtoFile
+}
+
+// ParseCacheStats are the usage statistics of a ParseCache.
+type ParseCacheStats struct {
+	Hits    int64
+	Misses  int64
+	Entries int
+}
+
+// Creates an empty parse cache.
+func NewParseCache() *ParseCache {
+	return &ParseCache{
+		entries: make(map[string]*parseCacheEntry),
+	}
+}
+
+// Returns the cached file for the path if it has the same content hash, or nil.
+func (c *ParseCache) Get(path, hash string) *ProtoFile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[path]; ok && e.hash == hash {
+		c.hits++
+		return CloneFile(e.file)
+	}
+	c.misses++
+	return nil
+}
+
+// Stores the parsed file for the path, replacing any previous one.
+func (c *ParseCache) Put(path, hash string, file *ProtoFile) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]*parseCacheEntry)
+	}
+	c.entries[path] = &parseCacheEntry{hash: hash, file: CloneFile(file)}
+}
+
+// Removes the path from the cache.
+func (c *ParseCache) Invalidate(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, path)
+}
+
+// Returns the cache usage statistics.
+func (c *ParseCache) Stats() ParseCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return ParseCacheStats{
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Entries: len(c.entries),
+	}
+}
+
+// Reads the files again from the import sources, and reparses the ones whose
+// content changed. Any new imports are loaded, and files no longer imported
+// are removed.
+//
+// Returns the changed files and all the files importing them, directly or
+// not, in load order. Those are the ones that must be linked again, see
+// Links.Relink. If an error occurs, the FileSet is not changed. Reloading a
+// file that is not loaded is a *FileNotLoaded error; use Load for new files.
+func (s *FileSet) Reload(paths ...string) ([]*ProtoFile, error) {
+	parsed := s.parsedFiles()
+
+	changed := make(map[string]bool)
+	for _, path := range paths {
+		if _, ok := parsed[path]; !ok {
+			return nil, &FileNotLoaded{Path: path}
+		}
+		location, data, err := s.readImport(path)
+		if err != nil {
+			return nil, err
+		}
+		if old, ok := parsed[path]; ok && ContentHash(old.data) == ContentHash(data) {
+			old.location = location
+			continue
+		}
+		pf := s.parseData(path, location, data)
+		if pf.err != nil {
+			return nil, pf.err
+		}
+		parsed[path] = pf
+		changed[path] = true
+	}
+
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	if err := s.rebuild(parsed, s.roots); err != nil {
+		return nil, err
+	}
+
+	var ret []*ProtoFile
+	affected := make(map[string]bool)
+	for _, f := range s.Files {
+		if changed[f.FileName] {
+			affected[f.FileName] = true
+		} else {
+			for _, dep := range s.importsOf(f) {
+				if affected[dep] {
+					affected[f.FileName] = true
+					break
+				}
+			}
+		}
+		if affected[f.FileName] {
+			ret = append(ret, f)
+		}
+	}
+	return ret, nil
+}
+
+// Removes files that were loaded explicitly, and the imports no other file
+// needs. Files still imported by other files are loaded again from the
+// sources; if not found there, an *ImportNotFound error is returned and the
+// FileSet is not changed.
+func (s *FileSet) Unload(paths ...string) error {
+	parsed := s.parsedFiles()
+	remove := make(map[string]bool)
+	for _, path := range paths {
+		remove[path] = true
+		delete(parsed, path)
+	}
+
+	var roots []string
+	for _, root := range s.roots {
+		if !remove[root] {
+			roots = append(roots, root)
+		}
+	}
+	return s.rebuild(parsed, roots)
+}
+
+// The loaded files as parse results.
+func (s *FileSet) parsedFiles() map[string]*parsedFile {
+	ret := make(map[string]*parsedFile)
+	for path, e := range s.files {
+		ret[path] = &parsedFile{file: e.file, location: e.location, data: e.data}
+	}
+	return ret
+}
+
+// Loads again from the roots, so the order is the same as a new load.
+// Parse results not found are loaded from the sources.
+func (s *FileSet) rebuild(parsed map[string]*parsedFile, roots []string) error {
+	oldFiles, oldFileList, oldSymbols, oldRoots := s.files, s.Files, s.symbols, s.roots
+	s.files, s.Files, s.symbols = nil, nil, nil
+	for _, root := range roots {
+		if _, err := s.loadFile(root, parsed); err != nil {
+			s.files, s.Files, s.symbols, s.roots = oldFiles, oldFileList, oldSymbols, oldRoots
+			return err
+		}
+	}
+	s.roots = roots
+	return nil
+}
+
+// Returns all the loaded files that import the file, directly or not, in load order.
+func (s *FileSet) ReverseDependencies(path string) []*ProtoFile {
+	var ret []*ProtoFile
+	affected := map[string]bool{path: true}
+	for _, f := range s.Files {
+		for _, dep := range s.importsOf(f) {
+			if affected[dep] {
+				affected[f.FileName] = true
+				ret = append(ret, f)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+func (s *FileSet) importsOf(f *ProtoFile) []string {
+	return append(append([]string(nil), f.Dependencies...), f.WeakDependencies...)
+}
@@ -0,0 +1,85 @@
+// Command fproto-query prints the elements of proto files matching a query.
+//
+// Usage:
+//
+//	fproto-query [-I dir]... [-imports] query file.proto...
+//
+// Files are import paths searched in the include directories, like protoc.
+// Each match is printed as "file: TYPE full.name". The exit status is 0 if
+// any element matched, 1 if none did and 2 on errors.
+//
+// See fproto.Query for the query syntax.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/RangelReale/fproto"
+)
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func main() {
+	var includes stringList
+	flag.Var(&includes, "I", "include directory, can be repeated (default \".\")")
+	imports := flag.Bool("imports", false, "also query the imported files")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-I dir]... [-imports] query file.proto...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if len(includes) == 0 {
+		includes = append(includes, ".")
+	}
+
+	q, err := fproto.ParseQuery(flag.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+
+	fs := fproto.NewFileSet(fproto.NewIncludePathSource(includes...))
+	if err := fs.Load(flag.Args()[1:]...); err != nil {
+		fatal(err)
+	}
+
+	files := fs.Files
+	if !*imports {
+		files = nil
+		for _, fn := range flag.Args()[1:] {
+			files = append(files, fs.FindFile(fn))
+		}
+	}
+
+	found := false
+	for _, f := range files {
+		for el := range q.All(f) {
+			found = true
+			fmt.Printf("%s: %s %s\n", f.FileName, el.ElementTypeName(), fproto.FullName(el))
+		}
+	}
+	if !found {
+		os.Exit(1)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "fproto-query: %v\n", err)
+	os.Exit(2)
+}
@@ -0,0 +1,883 @@
+package fproto
+
+import (
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Highest field number, exclusive, used for "max" in ranges
+const descriptorMaxFieldNumber = 536870912
+
+var scalarDescriptorTypes = map[ScalarType]descriptorpb.FieldDescriptorProto_Type{
+	BoolScalar:     descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	BytesScalar:    descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+	DoubleScalar:   descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	FloatScalar:    descriptorpb.FieldDescriptorProto_TYPE_FLOAT,
+	Fixed32Scalar:  descriptorpb.FieldDescriptorProto_TYPE_FIXED32,
+	Fixed64Scalar:  descriptorpb.FieldDescriptorProto_TYPE_FIXED64,
+	Int32Scalar:    descriptorpb.FieldDescriptorProto_TYPE_INT32,
+	Int64Scalar:    descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	Sfixed32Scalar: descriptorpb.FieldDescriptorProto_TYPE_SFIXED32,
+	Sfixed64Scalar: descriptorpb.FieldDescriptorProto_TYPE_SFIXED64,
+	Sint32Scalar:   descriptorpb.FieldDescriptorProto_TYPE_SINT32,
+	Sint64Scalar:   descriptorpb.FieldDescriptorProto_TYPE_SINT64,
+	StringScalar:   descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	Uint32Scalar:   descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	Uint64Scalar:   descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+}
+
+// Converts the file to a FileDescriptorProto.
+//
+// If links is not nil, type names are fully qualified and field types are set,
+// like protoc output. Otherwise type names are kept as written, like the protoc
+// parser output before linking.
+//
+// Standard options are set on the options messages. Custom options are set as
+// extension fields when links resolve the extension, like protoc, and added as
+// uninterpreted options otherwise. Map fields get their map entry nested messages,
+// added after the declared nested messages. Comments are added to the source
+// code info, with empty spans as fproto doesn't keep source positions.
+func ToFileDescriptorProto(f *ProtoFile, links *Links) *descriptorpb.FileDescriptorProto {
+	b := &descriptorBuilder{
+		links: links,
+		file:  f,
+		sci:   &descriptorpb.SourceCodeInfo{},
+	}
+	return b.buildFile()
+}
+
+// Converts all the loaded files to a FileDescriptorSet, dependencies before
+// the files that import them. See ToFileDescriptorProto.
+func (s *FileSet) ToFileDescriptorSet(links *Links) *descriptorpb.FileDescriptorSet {
+	ret := &descriptorpb.FileDescriptorSet{}
+	for _, f := range s.Files {
+		ret.File = append(ret.File, ToFileDescriptorProto(f, links))
+	}
+	return ret
+}
+
+// Writes the descriptor set in the binary .protoset format, like "protoc --descriptor_set_out".
+func WriteProtoset(w io.Writer, set *descriptorpb.FileDescriptorSet) error {
+	data, err := proto.Marshal(set)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Writes the descriptor set to a .protoset file.
+func WriteProtosetFile(fn string, set *descriptorpb.FileDescriptorSet) error {
+	data, err := proto.Marshal(set)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fn, data, 0644)
+}
+
+type descriptorBuilder struct {
+	links *Links
+	file  *ProtoFile
+	sci   *descriptorpb.SourceCodeInfo
+}
+
+func (b *descriptorBuilder) buildFile() *descriptorpb.FileDescriptorProto {
+	f := b.file
+	ret := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String(f.FileName),
+		Dependency: f.Dependencies,
+	}
+	if f.PackageName != "" {
+		ret.Package = proto.String(f.PackageName)
+	}
+	if f.Syntax != "" && f.Syntax != "proto2" {
+		ret.Syntax = proto.String(f.Syntax)
+	}
+	for _, dep := range f.PublicDependencies {
+		ret.PublicDependency = append(ret.PublicDependency, int32(indexStr(f.Dependencies, dep)))
+	}
+	for _, dep := range f.WeakDependencies {
+		// weak dependencies are only in the weak list in fproto
+		ret.Dependency = append(ret.Dependency, dep)
+		ret.WeakDependency = append(ret.WeakDependency, int32(len(ret.Dependency)-1))
+	}
+
+	for i, el := range f.Messages {
+		ret.MessageType = append(ret.MessageType, b.buildMessage(el, []int32{4, int32(i)}))
+	}
+	for i, el := range f.Enums {
+		ret.EnumType = append(ret.EnumType, b.buildEnum(el, []int32{5, int32(i)}))
+	}
+	for i, el := range f.Services {
+		ret.Service = append(ret.Service, b.buildService(el, []int32{6, int32(i)}))
+	}
+	ret.Extension = b.buildExtensions(f.ExtendMessages, []int32{7})
+
+	if len(f.Options) > 0 {
+		ret.Options = &descriptorpb.FileOptions{}
+		b.buildOptions(ret.Options, f.Options)
+	}
+
+	if len(b.sci.Location) > 0 {
+		ret.SourceCodeInfo = b.sci
+	}
+	return ret
+}
+
+func (b *descriptorBuilder) buildMessage(m *MessageElement, path []int32) *descriptorpb.DescriptorProto {
+	b.addComment(path, m.Comment)
+
+	ret := &descriptorpb.DescriptorProto{
+		Name:         proto.String(m.Name),
+		ReservedName: m.ReservedNames,
+	}
+
+	for i, el := range m.Messages {
+		ret.NestedType = append(ret.NestedType, b.buildMessage(el, appendPath(path, 3, i)))
+	}
+	for i, el := range m.Enums {
+		ret.EnumType = append(ret.EnumType, b.buildEnum(el, appendPath(path, 4, i)))
+	}
+
+	// fields, with oneof fields flattened
+	var syntheticOneofs []*descriptorpb.FieldDescriptorProto
+	for _, fld := range m.Fields {
+		switch xfld := fld.(type) {
+		case *FieldElement:
+			fd := b.buildField(xfld, appendPath(path, 2, len(ret.Field)))
+			if b.file.Syntax == "proto3" && xfld.Optional {
+				fd.Proto3Optional = proto.Bool(true)
+				syntheticOneofs = append(syntheticOneofs, fd)
+			}
+			ret.Field = append(ret.Field, fd)
+		case *MapFieldElement:
+			entry := b.buildMapEntry(xfld)
+			ret.NestedType = append(ret.NestedType, entry)
+			fd := b.buildField(xfld.FieldElement, appendPath(path, 2, len(ret.Field)))
+			fd.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			fd.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			fd.TypeName = proto.String(entry.GetName())
+			if b.links != nil {
+				fd.TypeName = proto.String("." + FullName(m) + "." + entry.GetName())
+			}
+			ret.Field = append(ret.Field, fd)
+		case *OneOfFieldElement:
+			oneofIndex := int32(len(ret.OneofDecl))
+			opath := appendPath(path, 8, int(oneofIndex))
+			b.addComment(opath, xfld.Comment)
+			od := &descriptorpb.OneofDescriptorProto{
+				Name: proto.String(xfld.Name),
+			}
+			if len(xfld.Options) > 0 {
+				od.Options = &descriptorpb.OneofOptions{}
+				b.buildOptions(od.Options, xfld.Options)
+			}
+			ret.OneofDecl = append(ret.OneofDecl, od)
+			for _, ofld := range xfld.Fields {
+				if of, ok := ofld.(*FieldElement); ok {
+					fd := b.buildField(of, appendPath(path, 2, len(ret.Field)))
+					fd.OneofIndex = proto.Int32(oneofIndex)
+					ret.Field = append(ret.Field, fd)
+				}
+			}
+		}
+	}
+	// proto3 optional fields have synthetic oneofs after the real ones
+	for _, fd := range syntheticOneofs {
+		fd.OneofIndex = proto.Int32(int32(len(ret.OneofDecl)))
+		ret.OneofDecl = append(ret.OneofDecl, &descriptorpb.OneofDescriptorProto{
+			Name: proto.String("_" + fd.GetName()),
+		})
+	}
+
+	for i, el := range m.Extensions {
+		b.addComment(appendPath(path, 5, i), el.Comment)
+		ret.ExtensionRange = append(ret.ExtensionRange, &descriptorpb.DescriptorProto_ExtensionRange{
+			Start: proto.Int32(int32(el.Start)),
+			End:   proto.Int32(rangeEnd(el.End, el.IsMax)),
+		})
+	}
+	for i, el := range m.ReservedRanges {
+		b.addComment(appendPath(path, 9, i), el.Comment)
+		ret.ReservedRange = append(ret.ReservedRange, &descriptorpb.DescriptorProto_ReservedRange{
+			Start: proto.Int32(int32(el.Start)),
+			End:   proto.Int32(rangeEnd(el.End, el.IsMax)),
+		})
+	}
+	ret.Extension = b.buildExtensions(m.ExtendMessages, appendPath(path, 6))
+
+	if len(m.Options) > 0 {
+		ret.Options = &descriptorpb.MessageOptions{}
+		b.buildOptions(ret.Options, m.Options)
+	}
+
+	return ret
+}
+
+// fields of extend blocks, flattened
+func (b *descriptorBuilder) buildExtensions(extends []*MessageElement, path []int32) []*descriptorpb.FieldDescriptorProto {
+	var ret []*descriptorpb.FieldDescriptorProto
+	for _, ext := range extends {
+		extendee := ext.Name
+		if b.links != nil {
+			if target := b.links.ExtendTarget(ext); target != nil {
+				extendee = "." + FullName(target)
+			}
+		}
+		for _, fld := range ext.Fields {
+			if f, ok := fld.(*FieldElement); ok {
+				fd := b.buildField(f, appendPath(path, len(ret)))
+				fd.Extendee = proto.String(extendee)
+				ret = append(ret, fd)
+			}
+		}
+	}
+	return ret
+}
+
+func (b *descriptorBuilder) buildField(f *FieldElement, path []int32) *descriptorpb.FieldDescriptorProto {
+	b.addComment(path, f.Comment)
+
+	ret := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(f.Name),
+		Number:   proto.Int32(int32(f.Tag)),
+		JsonName: proto.String(JSONName(f.Name)),
+	}
+
+	switch {
+	case f.Repeated:
+		ret.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	case f.Required:
+		ret.Label = descriptorpb.FieldDescriptorProto_LABEL_REQUIRED.Enum()
+	default:
+		ret.Label = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	}
+
+	b.setFieldType(ret, f.Type, b.linkedType(f))
+
+	var options []*OptionElement
+	for _, o := range f.Options {
+		switch {
+		case isFieldDefaultOption(o):
+			ret.DefaultValue = proto.String(o.Value.Source)
+		case isFieldJSONNameOption(o):
+			ret.JsonName = proto.String(o.Value.Source)
+		default:
+			options = append(options, o)
+		}
+	}
+	if len(options) > 0 {
+		ret.Options = &descriptorpb.FieldOptions{}
+		b.buildOptions(ret.Options, options)
+	}
+
+	return ret
+}
+
+// Returns the linked type of the field or map field value.
+func (b *descriptorBuilder) linkedType(f *FieldElement) FProtoElement {
+	if b.links == nil {
+		return nil
+	}
+	if mf, ok := f.Parent.(*MessageElement); ok {
+		// map fields are referenced by the MapFieldElement, which contains the FieldElement
+		for _, fld := range mf.Fields {
+			if xfld, ok := fld.(*MapFieldElement); ok && xfld.FieldElement == f {
+				return b.links.MapValueType(xfld)
+			}
+		}
+	}
+	return b.links.FieldType(f)
+}
+
+func (b *descriptorBuilder) setFieldType(fd *descriptorpb.FieldDescriptorProto, typeName string, target FProtoElement) {
+	if st, ok := scalarLookupMap[typeName]; ok {
+		fd.Type = scalarDescriptorTypes[st].Enum()
+		return
+	}
+	switch target.(type) {
+	case *MessageElement:
+		fd.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		fd.TypeName = proto.String("." + FullName(target))
+	case *EnumElement:
+		fd.Type = descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum()
+		fd.TypeName = proto.String("." + FullName(target))
+	default:
+		fd.TypeName = proto.String(typeName)
+	}
+}
+
+func (b *descriptorBuilder) buildMapEntry(f *MapFieldElement) *descriptorpb.DescriptorProto {
+	key := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String("key"),
+		Number:   proto.Int32(1),
+		JsonName: proto.String("key"),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+	}
+	b.setFieldType(key, f.KeyType, nil)
+
+	value := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String("value"),
+		Number:   proto.Int32(2),
+		JsonName: proto.String("value"),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+	}
+	var target FProtoElement
+	if b.links != nil {
+		target = b.links.MapValueType(f)
+	}
+	b.setFieldType(value, f.Type, target)
+
+	return &descriptorpb.DescriptorProto{
+		Name:  proto.String(MapEntryName(f.Name)),
+		Field: []*descriptorpb.FieldDescriptorProto{key, value},
+		Options: &descriptorpb.MessageOptions{
+			MapEntry: proto.Bool(true),
+		},
+	}
+}
+
+func (b *descriptorBuilder) buildEnum(e *EnumElement, path []int32) *descriptorpb.EnumDescriptorProto {
+	b.addComment(path, e.Comment)
+
+	ret := &descriptorpb.EnumDescriptorProto{
+		Name: proto.String(e.Name),
+	}
+	for i, c := range e.EnumConstants {
+		b.addComment(appendPath(path, 2, i), c.Comment)
+		v := &descriptorpb.EnumValueDescriptorProto{
+			Name:   proto.String(c.Name),
+			Number: proto.Int32(int32(c.Tag)),
+		}
+		if len(c.Options) > 0 {
+			v.Options = &descriptorpb.EnumValueOptions{}
+			b.buildOptions(v.Options, c.Options)
+		}
+		ret.Value = append(ret.Value, v)
+	}
+	if len(e.Options) > 0 {
+		ret.Options = &descriptorpb.EnumOptions{}
+		b.buildOptions(ret.Options, e.Options)
+	}
+	return ret
+}
+
+func (b *descriptorBuilder) buildService(s *ServiceElement, path []int32) *descriptorpb.ServiceDescriptorProto {
+	b.addComment(path, s.Comment)
+
+	ret := &descriptorpb.ServiceDescriptorProto{
+		Name: proto.String(s.Name),
+	}
+	for i, rpc := range s.RPCs {
+		b.addComment(appendPath(path, 2, i), rpc.Comment)
+		md := &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(rpc.Name),
+			InputType:  proto.String(rpc.RequestType),
+			OutputType: proto.String(rpc.ResponseType),
+		}
+		if b.links != nil {
+			if t := b.links.RequestType(rpc); t != nil {
+				md.InputType = proto.String("." + FullName(t))
+			}
+			if t := b.links.ResponseType(rpc); t != nil {
+				md.OutputType = proto.String("." + FullName(t))
+			}
+		}
+		if rpc.StreamsRequest {
+			md.ClientStreaming = proto.Bool(true)
+		}
+		if rpc.StreamsResponse {
+			md.ServerStreaming = proto.Bool(true)
+		}
+		if len(rpc.Options) > 0 {
+			md.Options = &descriptorpb.MethodOptions{}
+			b.buildOptions(md.Options, rpc.Options)
+		}
+		ret.Method = append(ret.Method, md)
+	}
+	if len(s.Options) > 0 {
+		ret.Options = &descriptorpb.ServiceOptions{}
+		b.buildOptions(ret.Options, s.Options)
+	}
+	return ret
+}
+
+// Sets the standard options and the custom options with a linked extension on
+// the options message, and adds the others as uninterpreted options.
+func (b *descriptorBuilder) buildOptions(opts proto.Message, options []*OptionElement) {
+	m := opts.ProtoReflect()
+	uninterpreted := m.Descriptor().Fields().ByName("uninterpreted_option")
+
+	for _, o := range options {
+		if setStandardOption(m, o) || b.setExtensionOption(m, o) {
+			continue
+		}
+		list := m.Mutable(uninterpreted).List()
+		list.Append(protoreflect.ValueOfMessage(uninterpretedOption(o).ProtoReflect()))
+	}
+}
+
+func (b *descriptorBuilder) addComment(path []int32, c *Comment) {
+	if c == nil || len(c.Lines) == 0 {
+		return
+	}
+	b.sci.Location = append(b.sci.Location, &descriptorpb.SourceCodeInfo_Location{
+		Path:            append([]int32(nil), path...),
+		Span:            []int32{0, 0, 0},
+		LeadingComments: proto.String(strings.Join(c.Lines, "\n") + "\n"),
+	})
+}
+
+// Field options that are set on the field descriptor
+func isFieldDefaultOption(o *OptionElement) bool {
+	return !o.IsParenthesized && o.Name == "default" && o.Value != nil
+}
+
+func isFieldJSONNameOption(o *OptionElement) bool {
+	return !o.IsParenthesized && o.Name == "json_name" && o.Value != nil
+}
+
+// Returns an empty options message for the element, and its field number in the element descriptor.
+func newDescriptorOptions(element FProtoElement) (proto.Message, int) {
+	switch element.(type) {
+	case *ProtoFile:
+		return &descriptorpb.FileOptions{}, pathFileOptions
+	case *MessageElement:
+		return &descriptorpb.MessageOptions{}, pathMessageOptions
+	case *FieldElement, *MapFieldElement:
+		return &descriptorpb.FieldOptions{}, pathFieldOptions
+	case *OneOfFieldElement:
+		return &descriptorpb.OneofOptions{}, pathOneofOptions
+	case *EnumElement:
+		return &descriptorpb.EnumOptions{}, pathEnumOptions
+	case *EnumConstantElement:
+		return &descriptorpb.EnumValueOptions{}, pathEnumValueOptions
+	case *ServiceElement:
+		return &descriptorpb.ServiceOptions{}, pathServiceOptions
+	case *RPCElement:
+		return &descriptorpb.MethodOptions{}, pathMethodOptions
+	}
+	return nil, 0
+}
+
+// Returns the options of the element.
+func elementOptions(element FProtoElement) []*OptionElement {
+	switch el := element.(type) {
+	case *ProtoFile:
+		return el.Options
+	case *MessageElement:
+		return el.Options
+	case *FieldElement:
+		return el.Options
+	case *MapFieldElement:
+		return el.FieldElement.Options
+	case *OneOfFieldElement:
+		return el.Options
+	case *EnumElement:
+		return el.Options
+	case *EnumConstantElement:
+		return el.Options
+	case *ServiceElement:
+		return el.Options
+	case *RPCElement:
+		return el.Options
+	}
+	return nil
+}
+
+// Returns whether the option is set on the options message by buildOptions,
+// instead of being added as uninterpreted.
+func isStandardOption(opts proto.Message, o *OptionElement) bool {
+	return setStandardOption(opts.ProtoReflect().New(), o)
+}
+
+// Sets the option if it is a non-repeated scalar or enum field of the options message.
+func setStandardOption(m protoreflect.Message, o *OptionElement) bool {
+	if o.IsParenthesized || o.Value == nil || o.AggregatedValues != nil {
+		return false
+	}
+	fd := m.Descriptor().Fields().ByName(protoreflect.Name(o.Name))
+	if fd == nil || fd.IsList() || fd.Message() != nil {
+		return false
+	}
+
+	src := o.Value.Source
+	var v protoreflect.Value
+	var err error
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		var bv bool
+		bv, err = strconv.ParseBool(src)
+		v = protoreflect.ValueOfBool(bv)
+	case protoreflect.EnumKind:
+		ev := fd.Enum().Values().ByName(protoreflect.Name(src))
+		if ev == nil {
+			return false
+		}
+		v = protoreflect.ValueOfEnum(ev.Number())
+	case protoreflect.StringKind:
+		v = protoreflect.ValueOfString(src)
+	case protoreflect.BytesKind:
+		v = protoreflect.ValueOfBytes([]byte(src))
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		var iv int64
+		iv, err = strconv.ParseInt(src, 0, 32)
+		v = protoreflect.ValueOfInt32(int32(iv))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		var iv int64
+		iv, err = strconv.ParseInt(src, 0, 64)
+		v = protoreflect.ValueOfInt64(iv)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		var uv uint64
+		uv, err = strconv.ParseUint(src, 0, 32)
+		v = protoreflect.ValueOfUint32(uint32(uv))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		var uv uint64
+		uv, err = strconv.ParseUint(src, 0, 64)
+		v = protoreflect.ValueOfUint64(uv)
+	case protoreflect.FloatKind:
+		var fv float64
+		fv, err = strconv.ParseFloat(src, 32)
+		v = protoreflect.ValueOfFloat32(float32(fv))
+	case protoreflect.DoubleKind:
+		var fv float64
+		fv, err = strconv.ParseFloat(src, 64)
+		v = protoreflect.ValueOfFloat64(fv)
+	default:
+		return false
+	}
+	if err != nil {
+		return false
+	}
+
+	m.Set(fd, v)
+	return true
+}
+
+// Sets the custom option as an extension field of the options message, like
+// protoc, if the links resolve the extension. The extension types are not
+// available, so the field is added to the unknown fields in wire format.
+func (b *descriptorBuilder) setExtensionOption(m protoreflect.Message, o *OptionElement) bool {
+	if b.links == nil {
+		return false
+	}
+	ext := b.links.OptionExtension(o)
+	if ext == nil {
+		return false
+	}
+	target := b.links.ExtendTarget(ext.Parent.(*MessageElement))
+	if target == nil || FullName(target) != string(m.Descriptor().FullName()) {
+		return false
+	}
+
+	var data []byte
+	ok := false
+	switch {
+	case o.NPName != "":
+		// (extension).field = value
+		data, ok = b.appendOptionMessage(nil, ext, map[string]*Literal{o.NPName: o.Value})
+	case o.AggregatedValues != nil:
+		data, ok = b.appendOptionMessage(nil, ext, o.AggregatedValues)
+	default:
+		data, ok = b.appendOptionValue(nil, ext, o.Value)
+	}
+	if !ok {
+		return false
+	}
+	m.SetUnknown(append(m.GetUnknown(), data...))
+	return true
+}
+
+// Appends the message field with the values of its non-message fields, by name.
+func (b *descriptorBuilder) appendOptionMessage(data []byte, fld *FieldElement, values map[string]*Literal) ([]byte, bool) {
+	msg, ok := b.links.FieldType(fld).(*MessageElement)
+	if !ok {
+		return nil, false
+	}
+
+	var content []byte
+	found := 0
+	for _, f := range messageFields(msg) {
+		sfld, ok := f.(*FieldElement)
+		if !ok {
+			continue
+		}
+		if v, ok := values[sfld.Name]; ok {
+			if content, ok = b.appendOptionValue(content, sfld, v); !ok {
+				return nil, false
+			}
+			found++
+		}
+	}
+	if found != len(values) {
+		// unknown or nested field names
+		return nil, false
+	}
+
+	data = protowire.AppendTag(data, protowire.Number(fld.Tag), protowire.BytesType)
+	return protowire.AppendBytes(data, content), true
+}
+
+// Appends the scalar or enum field with the value, or with each item for repeated fields.
+func (b *descriptorBuilder) appendOptionValue(data []byte, fld *FieldElement, value *Literal) ([]byte, bool) {
+	if value == nil {
+		return nil, false
+	}
+	if value.Array != nil {
+		if !fld.Repeated {
+			return nil, false
+		}
+		for _, item := range value.Array {
+			var ok bool
+			if data, ok = b.appendOptionValue(data, fld, item); !ok {
+				return nil, false
+			}
+		}
+		return data, true
+	}
+
+	num := protowire.Number(fld.Tag)
+	src := value.Source
+	if st, isscalar := scalarLookupMap[fld.Type]; isscalar {
+		return appendScalarOption(data, num, st, src)
+	}
+	e, ok := b.links.FieldType(fld).(*EnumElement)
+	if !ok {
+		return nil, false
+	}
+	for _, c := range e.EnumConstants {
+		if c.Name == src {
+			data = protowire.AppendTag(data, num, protowire.VarintType)
+			return protowire.AppendVarint(data, uint64(int64(c.Tag))), true
+		}
+	}
+	return nil, false
+}
+
+// Appends the scalar field in wire format, parsing the value like setStandardOption.
+func appendScalarOption(data []byte, num protowire.Number, st ScalarType, src string) ([]byte, bool) {
+	var err error
+	switch st {
+	case BoolScalar:
+		var bv bool
+		bv, err = strconv.ParseBool(src)
+		data = protowire.AppendTag(data, num, protowire.VarintType)
+		data = protowire.AppendVarint(data, protowire.EncodeBool(bv))
+	case StringScalar, BytesScalar:
+		data = protowire.AppendTag(data, num, protowire.BytesType)
+		data = protowire.AppendString(data, src)
+	case Int32Scalar, Int64Scalar:
+		var iv int64
+		iv, err = strconv.ParseInt(src, 0, scalarBits(st))
+		data = protowire.AppendTag(data, num, protowire.VarintType)
+		data = protowire.AppendVarint(data, uint64(iv))
+	case Sint32Scalar, Sint64Scalar:
+		var iv int64
+		iv, err = strconv.ParseInt(src, 0, scalarBits(st))
+		data = protowire.AppendTag(data, num, protowire.VarintType)
+		data = protowire.AppendVarint(data, protowire.EncodeZigZag(iv))
+	case Uint32Scalar, Uint64Scalar:
+		var uv uint64
+		uv, err = strconv.ParseUint(src, 0, scalarBits(st))
+		data = protowire.AppendTag(data, num, protowire.VarintType)
+		data = protowire.AppendVarint(data, uv)
+	case Fixed32Scalar:
+		var uv uint64
+		uv, err = strconv.ParseUint(src, 0, 32)
+		data = protowire.AppendTag(data, num, protowire.Fixed32Type)
+		data = protowire.AppendFixed32(data, uint32(uv))
+	case Sfixed32Scalar:
+		var iv int64
+		iv, err = strconv.ParseInt(src, 0, 32)
+		data = protowire.AppendTag(data, num, protowire.Fixed32Type)
+		data = protowire.AppendFixed32(data, uint32(int32(iv)))
+	case Fixed64Scalar:
+		var uv uint64
+		uv, err = strconv.ParseUint(src, 0, 64)
+		data = protowire.AppendTag(data, num, protowire.Fixed64Type)
+		data = protowire.AppendFixed64(data, uv)
+	case Sfixed64Scalar:
+		var iv int64
+		iv, err = strconv.ParseInt(src, 0, 64)
+		data = protowire.AppendTag(data, num, protowire.Fixed64Type)
+		data = protowire.AppendFixed64(data, uint64(iv))
+	case FloatScalar:
+		var fv float64
+		fv, err = strconv.ParseFloat(src, 32)
+		data = protowire.AppendTag(data, num, protowire.Fixed32Type)
+		data = protowire.AppendFixed32(data, math.Float32bits(float32(fv)))
+	case DoubleScalar:
+		var fv float64
+		fv, err = strconv.ParseFloat(src, 64)
+		data = protowire.AppendTag(data, num, protowire.Fixed64Type)
+		data = protowire.AppendFixed64(data, math.Float64bits(fv))
+	default:
+		return nil, false
+	}
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func scalarBits(st ScalarType) int {
+	switch st {
+	case Int32Scalar, Sint32Scalar, Uint32Scalar:
+		return 32
+	}
+	return 64
+}
+
+// Custom option, to be interpreted by the descriptor consumer.
+func uninterpretedOption(o *OptionElement) *descriptorpb.UninterpretedOption {
+	ret := &descriptorpb.UninterpretedOption{}
+
+	name := o.Name
+	if o.IsParenthesized {
+		ret.Name = append(ret.Name, &descriptorpb.UninterpretedOption_NamePart{
+			NamePart:    proto.String(o.ParenthesizedName),
+			IsExtension: proto.Bool(true),
+		})
+		name = o.NPName
+	}
+	if name != "" {
+		for _, part := range strings.Split(name, ".") {
+			ret.Name = append(ret.Name, &descriptorpb.UninterpretedOption_NamePart{
+				NamePart:    proto.String(part),
+				IsExtension: proto.Bool(false),
+			})
+		}
+	}
+
+	if o.AggregatedValues != nil && !(o.IsParenthesized && o.NPName != "") {
+		ret.AggregateValue = proto.String(aggregateSource(o.AggregatedValues))
+		return ret
+	}
+
+	if o.Value == nil {
+		return ret
+	}
+	src := o.Value.Source
+	switch {
+	case o.Value.IsString:
+		ret.StringValue = []byte(src)
+	case o.Value.Array != nil:
+		ret.AggregateValue = proto.String(literalSource(o.Value))
+	default:
+		if uv, err := strconv.ParseUint(src, 0, 64); err == nil {
+			ret.PositiveIntValue = proto.Uint64(uv)
+		} else if iv, err := strconv.ParseInt(src, 0, 64); err == nil {
+			ret.NegativeIntValue = proto.Int64(iv)
+		} else if fv, err := strconv.ParseFloat(src, 64); err == nil {
+			ret.DoubleValue = proto.Float64(fv)
+		} else {
+			ret.IdentifierValue = proto.String(src)
+		}
+	}
+	return ret
+}
+
+// Text format source of the aggregated values, sorted by name.
+func aggregateSource(values map[string]*Literal) string {
+	var keys []string
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		parts = append(parts, k+": "+literalSource(values[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func literalSource(l *Literal) string {
+	if l == nil {
+		return ""
+	}
+	if l.Array != nil {
+		var items []string
+		for _, a := range l.Array {
+			items = append(items, literalSource(a))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	if l.IsString {
+		return strconv.Quote(l.Source)
+	}
+	return l.Source
+}
+
+// Returns the JSON name of the field, like protoc: underscores are removed
+// and the next letter is capitalized.
+func JSONName(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper && r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		upper = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Returns the name of the map entry message of a map field, like protoc:
+// "my_field" is "MyFieldEntry".
+func MapEntryName(fieldName string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range fieldName {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper && r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		upper = false
+		b.WriteRune(r)
+	}
+	return b.String() + "Entry"
+}
+
+func rangeEnd(end int, isMax bool) int32 {
+	if isMax {
+		return descriptorMaxFieldNumber
+	}
+	// descriptor ranges are exclusive
+	return int32(end + 1)
+}
+
+func appendPath(path []int32, items ...int) []int32 {
+	ret := make([]int32, len(path), len(path)+len(items))
+	copy(ret, path)
+	for _, i := range items {
+		ret = append(ret, int32(i))
+	}
+	return ret
+}
+
+func indexStr(list []string, s string) int {
+	for i, ls := range list {
+		if ls == s {
+			return i
+		}
+	}
+	return -1
+}
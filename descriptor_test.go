@@ -0,0 +1,423 @@
+package fproto
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var testdescriptorset = MapImportSource{
+	"shop/product.proto": `
+syntax = "proto3";
+package shop;
+option go_package = "example.com/shop";
+import "google/protobuf/descriptor.proto";
+
+extend google.protobuf.FieldOptions {
+	bool sensitive = 50000;
+}
+
+// A product
+message Product {
+	enum Status {
+		ACTIVE = 0;
+		DISABLED = 1 [deprecated = true];
+	}
+
+	string product_id = 1 [json_name = "id"];
+	Status status = 2;
+	map<string, Price> price_list = 3;
+	optional string cost_code = 4 [(sensitive) = true];
+	oneof stock {
+		int32 quantity = 5;
+		bool unlimited = 6;
+	}
+	reserved 10 to max;
+}
+
+message Price {
+	int64 cents = 1;
+}
+
+service ProductService {
+	rpc List(Product) returns (stream Product);
+}
+`,
+}
+
+func TestDescriptor(t *testing.T) {
+	fs := NewFileSet(testdescriptorset)
+	if err := fs.Load("shop/product.proto"); err != nil {
+		t.Fatalf("Error loading proto file: %v", err)
+	}
+	links, err := Link(fs)
+	if err != nil {
+		t.Fatalf("Error linking: %v", err)
+	}
+
+	set := fs.ToFileDescriptorSet(links)
+	if len(set.File) != 2 || set.File[0].GetName() != "google/protobuf/descriptor.proto" {
+		t.Fatalf("Dependencies should be before the files importing them")
+	}
+
+	// the descriptors must be accepted by the protobuf runtime
+	if _, err := protodesc.NewFiles(set); err != nil {
+		t.Fatalf("Invalid descriptor set: %v", err)
+	}
+
+	fd := set.File[1]
+	if fd.GetOptions().GetGoPackage() != "example.com/shop" {
+		t.Fatalf("go_package option not set")
+	}
+
+	product := fd.MessageType[0]
+	if len(product.NestedType) != 1 || product.NestedType[0].GetName() != "PriceListEntry" ||
+		!product.NestedType[0].GetOptions().GetMapEntry() {
+		t.Fatalf("Map entry message not generated")
+	}
+	if product.Field[0].GetJsonName() != "id" || product.Field[3].GetJsonName() != "costCode" {
+		t.Fatalf("Wrong json names")
+	}
+	if product.Field[1].GetTypeName() != ".shop.Product.Status" || product.Field[1].GetType() != descriptorpb.FieldDescriptorProto_TYPE_ENUM {
+		t.Fatalf("Enum field type not resolved")
+	}
+	if len(product.OneofDecl) != 2 || product.OneofDecl[1].GetName() != "_cost_code" ||
+		!product.Field[3].GetProto3Optional() || product.Field[4].GetOneofIndex() != 0 {
+		t.Fatalf("Wrong oneof indexes")
+	}
+	if product.ReservedRange[0].GetEnd() != descriptorMaxFieldNumber {
+		t.Fatalf("Reserved range to max should end at the max field number")
+	}
+	costOpts := product.Field[3].GetOptions()
+	if len(costOpts.GetUninterpretedOption()) != 0 ||
+		!bytes.Equal(costOpts.ProtoReflect().GetUnknown(), protowire.AppendVarint(protowire.AppendTag(nil, 50000, protowire.VarintType), 1)) {
+		t.Fatalf("Custom option should be set as the extension field")
+	}
+	unlinked := ToFileDescriptorProto(fs.FindFile("shop/product.proto"), nil)
+	if len(unlinked.MessageType[0].Field[3].GetOptions().GetUninterpretedOption()) != 1 {
+		t.Fatalf("Custom option should be uninterpreted without links")
+	}
+	if fd.SourceCodeInfo == nil || fd.SourceCodeInfo.Location[0].GetLeadingComments() != "A product\n" {
+		t.Fatalf("Comment not added to the source code info")
+	}
+
+	var buf bytes.Buffer
+	if err := WriteProtoset(&buf, set); err != nil {
+		t.Fatalf("Error writing protoset: %v", err)
+	}
+	var readSet descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(buf.Bytes(), &readSet); err != nil || !proto.Equal(set, &readSet) {
+		t.Fatalf("Protoset not read back equal: %v", err)
+	}
+}
+
+func TestFromDescriptor(t *testing.T) {
+	fs := NewFileSet(testdescriptorset)
+	if err := fs.Load("shop/product.proto"); err != nil {
+		t.Fatalf("Error loading proto file: %v", err)
+	}
+	links, err := Link(fs)
+	if err != nil {
+		t.Fatalf("Error linking: %v", err)
+	}
+
+	dfs, err := NewFileSetFromDescriptorSet(fs.ToFileDescriptorSet(links))
+	if err != nil {
+		t.Fatalf("Error loading descriptor set: %v", err)
+	}
+	if _, err := Link(dfs); err != nil {
+		t.Fatalf("Error linking descriptor set files: %v", err)
+	}
+
+	pfile := dfs.FindFile("shop/product.proto")
+	if pfile == nil || pfile.PackageName != "shop" || len(pfile.Dependencies) != 1 {
+		t.Fatalf("File not loaded from the descriptor set")
+	}
+
+	product := pfile.Messages[0]
+	if product.Comment == nil || product.Comment.Lines[0] != "A product" {
+		t.Fatalf("Message comment not restored")
+	}
+	if len(product.Messages) != 0 || len(product.Fields) != 5 {
+		t.Fatalf("Map entries and synthetic oneofs should be removed")
+	}
+
+	mf, ok := product.Fields[2].(*MapFieldElement)
+	if !ok || mf.KeyType != "string" || mf.Type != ".shop.Price" || mf.Parent != product {
+		t.Fatalf("Map field not restored")
+	}
+
+	cost, ok := product.Fields[3].(*FieldElement)
+	if !ok || !cost.Optional || len(cost.Options) != 1 || cost.Options[0].ParenthesizedName != "shop.sensitive" {
+		t.Fatalf("Optional field not restored")
+	}
+
+	oneof, ok := product.Fields[4].(*OneOfFieldElement)
+	if !ok || oneof.Name != "stock" || len(oneof.Fields) != 2 || oneof.Fields[0].ParentElement() != oneof {
+		t.Fatalf("Oneof not restored")
+	}
+
+	if o := product.Fields[0].FindOption("json_name"); o == nil || o.Value.Source != "id" {
+		t.Fatalf("json_name option not restored")
+	}
+
+	if len(pfile.ExtendMessages) != 1 || pfile.ExtendMessages[0].Name != ".google.protobuf.FieldOptions" {
+		t.Fatalf("Extend block not restored")
+	}
+}
+
+func TestDescriptorCustomOptions(t *testing.T) {
+	fs := NewFileSet(MapImportSource{
+		"opts/opts.proto": `
+syntax = "proto3";
+package opts;
+import "google/protobuf/descriptor.proto";
+
+enum Level {
+	LOW = 0;
+	HIGH = 1;
+}
+
+message Label {
+	string text = 1;
+	Level level = 2;
+	sint32 weight = 3;
+}
+
+extend google.protobuf.MessageOptions {
+	Label label = 50001;
+	repeated string tags = 50002;
+	Level level = 50003;
+	double ratio = 50004;
+}
+`,
+		"app/app.proto": `
+syntax = "proto3";
+package app;
+import "opts/opts.proto";
+
+message Item {
+	option (opts.label) = { text: "item" level: HIGH weight: -2 };
+	option (opts.tags) = "a";
+	option (opts.tags) = "b";
+	option (opts.level) = HIGH;
+	option (opts.ratio) = 0.5;
+	option (opts.label).text = "more";
+	option (unknown.opt) = 1;
+	option (opts.level) = MEDIUM;
+}
+`,
+	})
+	if err := fs.Load("app/app.proto"); err != nil {
+		t.Fatalf("Error loading proto file: %v", err)
+	}
+	links, err := Link(fs)
+	if err != nil {
+		t.Fatalf("Error linking: %v", err)
+	}
+
+	set := fs.ToFileDescriptorSet(links)
+	if _, err := protodesc.NewFiles(set); err != nil {
+		t.Fatalf("Invalid descriptor set: %v", err)
+	}
+	itemOpts := set.File[len(set.File)-1].MessageType[0].GetOptions()
+	if len(itemOpts.GetUninterpretedOption()) != 2 {
+		t.Fatalf("Only the unresolved option and the invalid value should be uninterpreted, got %d", len(itemOpts.GetUninterpretedOption()))
+	}
+
+	// read the extension fields back
+	files, err := FromFileDescriptorSet(set)
+	if err != nil {
+		t.Fatalf("Error loading descriptor set: %v", err)
+	}
+	var values []string
+	for _, o := range files[len(files)-1].Messages[0].Options {
+		if o.AggregatedValues != nil {
+			values = append(values, o.Name+" = {"+aggregateSource(o.AggregatedValues)+"}")
+		} else {
+			values = append(values, o.Name+" = "+literalSource(o.Value))
+		}
+	}
+	expected := []string{
+		`opts.label = {level: HIGH text: "more" weight: -2}`,
+		`opts.tags = ["a", "b"]`,
+		`opts.level = HIGH`,
+		`opts.ratio = 0.5`,
+		`unknown.opt = 1`,
+		`opts.level = MEDIUM`,
+	}
+	if strings.Join(values, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Wrong options read back:\n%s", strings.Join(values, "\n"))
+	}
+}
+
+func TestFromDescriptorInterpretedOptions(t *testing.T) {
+	fs := NewFileSet(testdescriptorset)
+	if err := fs.Load("shop/product.proto"); err != nil {
+		t.Fatalf("Error loading proto file: %v", err)
+	}
+	set := fs.ToFileDescriptorSet(nil)
+
+	// protoc writes the interpreted (sensitive) option as the extension field 50000,
+	// which is an unknown field when read without the extension linked in
+	var fd *descriptorpb.FileDescriptorProto
+	for _, f := range set.File {
+		if f.GetName() == "shop/product.proto" {
+			fd = f
+		}
+	}
+	opts := fd.MessageType[0].Field[3].Options
+	opts.UninterpretedOption = nil
+	opts.ProtoReflect().SetUnknown(protowire.AppendVarint(protowire.AppendTag(nil, 50000, protowire.VarintType), 1))
+
+	data, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatalf("Error marshaling descriptor set: %v", err)
+	}
+	set = &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		t.Fatalf("Error unmarshaling descriptor set: %v", err)
+	}
+
+	files, err := FromFileDescriptorSet(set)
+	if err != nil {
+		t.Fatalf("Error loading descriptor set: %v", err)
+	}
+	var pfile *ProtoFile
+	for _, f := range files {
+		if f.FileName == "shop/product.proto" {
+			pfile = f
+		}
+	}
+
+	cost := pfile.Messages[0].Fields[3].(*FieldElement)
+	if len(cost.Options) != 1 {
+		t.Fatalf("Interpreted option not restored")
+	}
+	o := cost.Options[0]
+	if o.Name != "shop.sensitive" || !o.IsParenthesized || o.Value.Source != "true" || o.Parent != cost {
+		t.Fatalf("Interpreted option restored incorrectly: %s = %s", o.Name, o.Value.Source)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	fs := NewFileSet(testdescriptorset)
+	if err := fs.Load("shop/product.proto"); err != nil {
+		t.Fatalf("Error loading proto file: %v", err)
+	}
+	reg, err := BuildRegistry(fs, nil)
+	if err != nil {
+		t.Fatalf("Error building registry: %v", err)
+	}
+
+	msg, err := reg.NewMessage("shop.Product")
+	if err != nil {
+		t.Fatalf("Error creating dynamic message: %v", err)
+	}
+	err = protojson.UnmarshalOptions{Resolver: reg.Types()}.Unmarshal(
+		[]byte(`{"id": "p1", "status": "DISABLED", "priceList": {"a": {"cents": "10"}}, "quantity": 3}`), msg)
+	if err != nil {
+		t.Fatalf("Error unmarshaling JSON: %v", err)
+	}
+
+	md := msg.Descriptor()
+	product := fs.FindFile("shop/product.proto").Messages[0]
+	if reg.ElementOf(md) != product || reg.DescriptorOf(product) != md {
+		t.Fatalf("Message descriptor not mapped to the element")
+	}
+	if reg.ElementOf(md.Fields().ByName("price_list").MapValue()) != product.Fields[2] {
+		t.Fatalf("Map value should be mapped to the map field")
+	}
+	if reg.ElementOf(md.Oneofs().ByName("stock")) != product.Fields[4] {
+		t.Fatalf("Oneof descriptor not mapped to the element")
+	}
+	if reg.ElementOf(md.Oneofs().ByName("_cost_code")) != product.Fields[3] {
+		t.Fatalf("Synthetic oneof should be mapped to the optional field")
+	}
+	if reg.ElementOf(md.Fields().ByName("product_id").ParentFile()) != fs.FindFile("shop/product.proto") {
+		t.Fatalf("File descriptor not mapped to the file")
+	}
+}
+
+func TestPathOf(t *testing.T) {
+	fs := NewFileSet(testdescriptorset)
+	pfile, err := fs.LoadFile("shop/product.proto")
+	if err != nil {
+		t.Fatalf("Error loading proto file: %v", err)
+	}
+
+	product := pfile.Messages[0]
+	oneof := product.Fields[4].(*OneOfFieldElement)
+	status := product.Enums[0]
+	costCode := product.Fields[3].(*FieldElement)
+
+	tests := []struct {
+		element FProtoElement
+		path    []int32
+	}{
+		{pfile, []int32{}},
+		{pfile.Options[0], []int32{8, 11}},
+		{product, []int32{4, 0}},
+		{product.Fields[0], []int32{4, 0, 2, 0}},
+		{product.Fields[0].(*FieldElement).Options[0], []int32{4, 0, 2, 0, 10}},
+		{product.Fields[2], []int32{4, 0, 2, 2}},
+		{costCode.Options[0], []int32{4, 0, 2, 3, 8, 999, 0}},
+		{oneof, []int32{4, 0, 8, 0}},
+		{oneof.Fields[1], []int32{4, 0, 2, 5}},
+		{status.EnumConstants[1], []int32{4, 0, 4, 0, 2, 1}},
+		{status.EnumConstants[1].Options[0], []int32{4, 0, 4, 0, 2, 1, 3, 1}},
+		{product.ReservedRanges[0], []int32{4, 0, 9, 0}},
+		{pfile.ExtendMessages[0], []int32{7}},
+		{pfile.ExtendMessages[0].Fields[0], []int32{7, 0}},
+		{pfile.Services[0].RPCs[0], []int32{6, 0, 2, 0}},
+	}
+	for _, test := range tests {
+		path := PathOf(test.element)
+		if pathKey(path) != pathKey(test.path) || path == nil {
+			t.Fatalf("Wrong path for %s %s: %v, expected %v", test.element.ElementTypeName(), test.element.ElementName(), path, test.path)
+		}
+		if el := ElementAt(pfile, test.path); el != test.element {
+			t.Fatalf("Wrong element at %v: %v", test.path, el)
+		}
+	}
+
+	if ElementAt(pfile, []int32{4, 0, 3, 0, 2, 0}) != product.Fields[2] {
+		t.Fatalf("Map entry fields should return the map field")
+	}
+	if ElementAt(pfile, []int32{4, 5}) != nil {
+		t.Fatalf("Out of range path should return nil")
+	}
+
+	// the exported comments must use the same paths
+	fd := ToFileDescriptorProto(pfile, nil)
+	if ElementAt(pfile, fd.SourceCodeInfo.Location[0].Path) != product {
+		t.Fatalf("Source code info path doesn't match")
+	}
+}
+
+func TestFromDescriptorComments(t *testing.T) {
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:        proto.String("shop/comment.proto"),
+		Syntax:      proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("Product")}},
+		SourceCodeInfo: &descriptorpb.SourceCodeInfo{
+			Location: []*descriptorpb.SourceCodeInfo_Location{{
+				Path:            []int32{4, 0},
+				LeadingComments: proto.String(" Example:\n   id: 1\n"),
+			}},
+		},
+	}
+	pfile, err := FromFileDescriptorProto(fd)
+	if err != nil {
+		t.Fatalf("Error loading descriptor: %v", err)
+	}
+	if c := pfile.Messages[0].Comment; c == nil || strings.Join(c.Lines, "|") != "Example:|  id: 1" {
+		t.Fatalf("Comment indentation not kept: %v", c)
+	}
+}
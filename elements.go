@@ -224,6 +224,7 @@ type MessageElement struct {
 // This is populated by the parser and returned to the
 // client code.
 type ProtoFile struct {
+	FileName           string // import path, when loaded by a FileSet
 	PackageName        string
 	Syntax             string
 	Dependencies       []string
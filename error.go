@@ -1,5 +1,10 @@
 package fproto
 
+import (
+	"fmt"
+	"strings"
+)
+
 // This error is issued when the protobuf file is malformed
 type InvalidScope struct {
 	message string
@@ -8,3 +13,190 @@ type InvalidScope struct {
 func (e *InvalidScope) Error() string {
 	return e.message
 }
+
+// This error is issued when an import path could not be found in any import source
+type ImportNotFound struct {
+	Path string
+}
+
+func (e *ImportNotFound) Error() string {
+	return fmt.Sprintf("Import '%s' not found", e.Path)
+}
+
+// This error is issued when a file could not be parsed
+type FileParseError struct {
+	Path     string
+	Location string
+	Err      error
+}
+
+func (e *FileParseError) Error() string {
+	return fmt.Sprintf("Error parsing file '%s' (%s): %s", e.Path, e.Location, e.Err.Error())
+}
+
+// This error is issued when a file imports itself, directly or indirectly
+type ImportCycle struct {
+	Path string
+}
+
+func (e *ImportCycle) Error() string {
+	return fmt.Sprintf("Import cycle detected at '%s'", e.Path)
+}
+
+// This error is issued when an import path is absolute or not clean
+type InvalidImportPath struct {
+	Path string
+}
+
+func (e *InvalidImportPath) Error() string {
+	return fmt.Sprintf("Invalid import path '%s'", e.Path)
+}
+
+// This error is issued when a buf workspace depends on remote modules, which are never fetched
+type BufMissingDeps struct {
+	Deps   []string
+	Import string // import path that was not found, if any
+}
+
+func (e *BufMissingDeps) Error() string {
+	msg := fmt.Sprintf("Remote buf dependencies are not available locally: %s", strings.Join(e.Deps, ", "))
+	if e.Import != "" {
+		msg = fmt.Sprintf("Import '%s' not found. %s", e.Import, msg)
+	}
+	return msg
+}
+
+// This error is issued when two modules of a buf workspace have a file with the same import path
+type BufDuplicateFile struct {
+	Path  string
+	Files []string
+}
+
+func (e *BufDuplicateFile) Error() string {
+	return fmt.Sprintf("Import path '%s' found in more than one module: %s", e.Path, strings.Join(e.Files, ", "))
+}
+
+// This error is issued when a locked file contents don't match the lock hash
+type HashMismatch struct {
+	Path     string
+	Location string
+	Expected string
+	Actual   string // empty if the file is missing
+}
+
+func (e *HashMismatch) Error() string {
+	if e.Actual == "" {
+		return fmt.Sprintf("Locked file '%s' is missing at '%s'", e.Path, e.Location)
+	}
+	return fmt.Sprintf("Hash mismatch for '%s' at '%s': expected %s, got %s", e.Path, e.Location, e.Expected, e.Actual)
+}
+
+// This error is issued when the lockfile format version is not supported
+type LockVersionMismatch struct {
+	Version int
+}
+
+func (e *LockVersionMismatch) Error() string {
+	return fmt.Sprintf("Unsupported lockfile version %d", e.Version)
+}
+
+// This error is issued when type references could not be resolved by the linker
+type UnresolvedTypes struct {
+	Refs []*TypeRef
+}
+
+func (e *UnresolvedTypes) Error() string {
+	var msgs []string
+	for _, r := range e.Refs {
+		if r.NotVisibleFile != nil {
+			// same message as protoc
+			msgs = append(msgs, fmt.Sprintf("%s: \"%s\" seems to be defined in \"%s\", which is not imported by \"%s\".  To use it here, please add the necessary import.",
+				r.File.FileName, r.NotVisibleName, r.NotVisibleFile.FileName, r.File.FileName))
+		} else {
+			msgs = append(msgs, fmt.Sprintf("Unresolved %s", r.String()))
+		}
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// This error is issued when a snapshot can't be used and must be rebuilt
+type SnapshotStale struct {
+	Reason string
+}
+
+func (e *SnapshotStale) Error() string {
+	return fmt.Sprintf("Stale snapshot: %s", e.Reason)
+}
+
+// This error is issued when a file descriptor is not consistent
+type InvalidDescriptor struct {
+	File   string
+	Reason string
+}
+
+func (e *InvalidDescriptor) Error() string {
+	return fmt.Sprintf("Invalid descriptor for file '%s': %s", e.File, e.Reason)
+}
+
+// This error is issued when a protoc plugin fails or reports an error
+type PluginError struct {
+	Plugin  string
+	Message string
+}
+
+func (e *PluginError) Error() string {
+	return fmt.Sprintf("Plugin '%s' failed: %s", e.Plugin, e.Message)
+}
+
+// This error is issued when the generated files in the output directory are not up to date
+type OutputOutOfDate struct {
+	Changes []*OutputChange
+}
+
+func (e *OutputOutOfDate) Error() string {
+	var files []string
+	for _, c := range e.Changes {
+		files = append(files, fmt.Sprintf("%s (%s)", c.Name, c.Kind))
+	}
+	return fmt.Sprintf("Generated files are out of date: %s", strings.Join(files, ", "))
+}
+
+// This error is issued when a generated file not generated anymore was changed
+// since it was written, so it is not removed
+type OutputFileModified struct {
+	Name string
+}
+
+func (e *OutputFileModified) Error() string {
+	return fmt.Sprintf("Generated file '%s' was modified, remove it manually", e.Name)
+}
+
+// This error is issued when a query could not be parsed
+type QueryError struct {
+	Query   string
+	Pos     int
+	Message string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("Invalid query '%s' at position %d: %s", e.Query, e.Pos, e.Message)
+}
+
+// This error is issued when vendoring to a directory with proto files that were not vendored by fproto
+type VendorDirNotManaged struct {
+	Dir   string
+	Files []string
+}
+
+func (e *VendorDirNotManaged) Error() string {
+	return fmt.Sprintf("Directory '%s' has no lockfile and contains proto files not being vendored: %s", e.Dir, strings.Join(e.Files, ", "))
+}
+
+// This error is issued when reloading a file that is not loaded in the FileSet
+type FileNotLoaded struct {
+	Path string
+}
+
+func (e *FileNotLoaded) Error() string {
+	return fmt.Sprintf("File '%s' is not loaded", e.Path)
+}
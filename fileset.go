@@ -0,0 +1,225 @@
+package fproto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+)
+
+// FileSet is a set of proto files loaded by import path, together with all
+// their transitive imports.
+type FileSet struct {
+	// Sources searched for imports, in order. The builtin google/protobuf
+	// files are always searched after these.
+	Sources []ImportSource
+
+	// Loaded files, dependencies always before the files that import them.
+	Files []*ProtoFile
+
+	// Cache of parsed files, shared between FileSets. Optional.
+	Cache *ParseCache
+
+	files   map[string]*fileSetEntry
+	loading map[string]bool
+	symbols *SymbolTable
+	roots   []string
+}
+
+type fileSetEntry struct {
+	file     *ProtoFile
+	location string
+	data     []byte
+}
+
+// Creates a new FileSet searching the import sources in order.
+func NewFileSet(sources ...ImportSource) *FileSet {
+	return &FileSet{
+		Sources: sources,
+		files:   make(map[string]*fileSetEntry),
+		loading: make(map[string]bool),
+	}
+}
+
+// Loads the files and all their imports. Files already loaded are skipped.
+func (s *FileSet) Load(paths ...string) error {
+	for _, path := range paths {
+		if _, err := s.LoadFile(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Loads a file and all its imports, returning the parsed file.
+func (s *FileSet) LoadFile(path string) (*ProtoFile, error) {
+	pfile, err := s.loadFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.addRoot(path)
+	return pfile, nil
+}
+
+// Loads the file using the already parsed results, if available, so the
+// load order is always the same.
+func (s *FileSet) loadFile(path string, parsed map[string]*parsedFile) (*ProtoFile, error) {
+	if s.files == nil {
+		s.files = make(map[string]*fileSetEntry)
+		s.loading = make(map[string]bool)
+	}
+	if e, ok := s.files[path]; ok {
+		return e.file, nil
+	}
+	if s.loading[path] {
+		return nil, &ImportCycle{Path: path}
+	}
+
+	pf := parsed[path]
+	if pf == nil {
+		pf = s.parseImport(path)
+	}
+	if pf.err != nil {
+		return nil, pf.err
+	}
+	pfile := pf.file
+
+	s.loading[path] = true
+	defer delete(s.loading, path)
+
+	for _, dep := range pfile.Dependencies {
+		if _, err := s.loadFile(dep, parsed); err != nil {
+			return nil, err
+		}
+	}
+	for _, dep := range pfile.WeakDependencies {
+		// weak imports are allowed to be missing
+		if _, err := s.loadFile(dep, parsed); err != nil && !IsImportNotFound(err) {
+			return nil, err
+		}
+	}
+
+	s.files[path] = &fileSetEntry{
+		file:     pfile,
+		location: pf.location,
+		data:     pf.data,
+	}
+	s.Files = append(s.Files, pfile)
+	s.symbols = nil
+
+	return pfile, nil
+}
+
+// Result of parsing an import
+type parsedFile struct {
+	file     *ProtoFile
+	location string
+	data     []byte
+	err      error
+}
+
+func (s *FileSet) parseImport(path string) *parsedFile {
+	location, data, err := s.readImport(path)
+	if err != nil {
+		return &parsedFile{err: err}
+	}
+	return s.parseData(path, location, data)
+}
+
+func (s *FileSet) readImport(path string) (location string, data []byte, err error) {
+	r, location, err := s.openImport(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer r.Close()
+
+	data, err = ioutil.ReadAll(r)
+	if err != nil {
+		return "", nil, err
+	}
+	return location, data, nil
+}
+
+// Parses the file contents, or reuses it from the cache.
+func (s *FileSet) parseData(path, location string, data []byte) *parsedFile {
+	ret := &parsedFile{
+		location: location,
+		data:     data,
+	}
+
+	hash := ContentHash(data)
+	if s.Cache != nil {
+		if ret.file = s.Cache.Get(path, hash); ret.file != nil {
+			return ret
+		}
+	}
+
+	pfile, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		return &parsedFile{err: &FileParseError{Path: path, Location: location, Err: err}}
+	}
+	pfile.FileName = path
+
+	if s.Cache != nil {
+		s.Cache.Put(path, hash, pfile)
+	}
+
+	ret.file = pfile
+	return ret
+}
+
+// Files explicitly requested to load, in order
+func (s *FileSet) addRoot(path string) {
+	for _, r := range s.roots {
+		if r == path {
+			return
+		}
+	}
+	s.roots = append(s.roots, path)
+}
+
+// Finds a loaded file by import path. Returns nil if not loaded.
+func (s *FileSet) FindFile(path string) *ProtoFile {
+	if e, ok := s.files[path]; ok {
+		return e.file
+	}
+	return nil
+}
+
+// Returns where a loaded file was found by its import source.
+func (s *FileSet) Location(path string) string {
+	if e, ok := s.files[path]; ok {
+		return e.location
+	}
+	return ""
+}
+
+// Returns the source contents of a loaded file.
+func (s *FileSet) Content(path string) []byte {
+	if e, ok := s.files[path]; ok {
+		return e.data
+	}
+	return nil
+}
+
+// Returns the content hash of a loaded file, in the "sha256:<hex>" format.
+func (s *FileSet) Hash(path string) string {
+	if e, ok := s.files[path]; ok {
+		return ContentHash(e.data)
+	}
+	return ""
+}
+
+// Returns the hash of the file contents, in the "sha256:<hex>" format.
+func ContentHash(data []byte) string {
+	h := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(h[:])
+}
+
+func (s *FileSet) openImport(path string) (io.ReadCloser, string, error) {
+	sources := make(MultiImportSource, 0, len(s.Sources)+1)
+	sources = append(sources, s.Sources...)
+	sources = append(sources, BuiltinImportSource())
+	return sources.OpenImport(path)
+}
@@ -0,0 +1,113 @@
+package fproto
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// Loads the files and all their imports like Load, parsing them in parallel
+// with at most "workers" goroutines. If workers <= 0, GOMAXPROCS is used.
+//
+// Each import is parsed only once, and the resulting Files order and error
+// are the same as Load would return, whatever the scheduling.
+// The import sources must be safe for concurrent use.
+//
+// If the context is cancelled, no more files are parsed, the context error
+// is returned and the FileSet is not changed.
+func (s *FileSet) LoadParallel(ctx context.Context, workers int, paths ...string) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	parsed, err := s.parseParallel(ctx, workers, paths)
+	if err != nil {
+		return err
+	}
+
+	// link the results in the same order as a sequential load
+	for _, path := range paths {
+		if _, err := s.loadFile(path, parsed); err != nil {
+			return err
+		}
+		s.addRoot(path)
+	}
+	return nil
+}
+
+// Parses the files and all their imports not loaded yet.
+func (s *FileSet) parseParallel(ctx context.Context, workers int, paths []string) (map[string]*parsedFile, error) {
+	var (
+		mu      sync.Mutex
+		cond    = sync.NewCond(&mu)
+		queue   []string
+		pending int
+		parsed  = make(map[string]*parsedFile)
+		seen    = make(map[string]bool)
+	)
+
+	// must be called with the lock held
+	schedule := func(path string) {
+		if seen[path] || s.FindFile(path) != nil {
+			return
+		}
+		seen[path] = true
+		queue = append(queue, path)
+		pending++
+	}
+
+	for _, path := range paths {
+		schedule(path)
+	}
+
+	// wake up the idle workers on cancel
+	stop := context.AfterFunc(ctx, func() {
+		mu.Lock()
+		cond.Broadcast()
+		mu.Unlock()
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				for len(queue) == 0 && pending > 0 && ctx.Err() == nil {
+					cond.Wait()
+				}
+				if len(queue) == 0 || ctx.Err() != nil {
+					mu.Unlock()
+					return
+				}
+				path := queue[0]
+				queue = queue[1:]
+				mu.Unlock()
+
+				pf := s.parseImport(path)
+
+				mu.Lock()
+				parsed[path] = pf
+				if pf.err == nil {
+					for _, dep := range pf.file.Dependencies {
+						schedule(dep)
+					}
+					for _, dep := range pf.file.WeakDependencies {
+						schedule(dep)
+					}
+				}
+				pending--
+				cond.Broadcast()
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
@@ -0,0 +1,187 @@
+package fproto
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+var (
+	testfileset = MapImportSource{
+		"app/event.proto": `
+syntax = "proto3";
+package app;
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/descriptor.proto";
+
+extend google.protobuf.FieldOptions {
+	bool sensitive = 50000;
+}
+
+message Event {
+	string name = 1 [(sensitive) = true];
+	google.protobuf.Timestamp created_at = 2;
+}
+`,
+	}
+)
+
+func TestFileSetBuiltinImports(t *testing.T) {
+	fs := NewFileSet(testfileset)
+
+	pfile, err := fs.LoadFile("app/event.proto")
+	if err != nil {
+		t.Fatalf("Error loading proto file: %v", err)
+	}
+
+	if pfile.FileName != "app/event.proto" {
+		t.Fatalf("File name should be 'app/event.proto', but '%s' found", pfile.FileName)
+	}
+
+	if len(fs.Files) != 3 {
+		t.Fatalf("Expected 3 loaded files, got %d", len(fs.Files))
+	}
+
+	if fs.Files[2] != pfile {
+		t.Fatalf("Dependencies should be loaded before the importing file")
+	}
+
+	ts := fs.FindFile("google/protobuf/timestamp.proto")
+	if ts == nil {
+		t.Fatalf("google/protobuf/timestamp.proto should be loaded from the builtin source")
+	}
+
+	if len(ts.FindName("Timestamp")) != 1 {
+		t.Fatalf("google.protobuf.Timestamp not found")
+	}
+
+	if fs.Location("google/protobuf/descriptor.proto") != "builtin:google/protobuf/descriptor.proto" {
+		t.Fatalf("Unexpected location for descriptor.proto: '%s'", fs.Location("google/protobuf/descriptor.proto"))
+	}
+
+	if _, err := fs.LoadFile("app/missing.proto"); !IsImportNotFound(err) {
+		t.Fatalf("Expected ImportNotFound error, got %v", err)
+	}
+}
+
+func TestFileSetLoadParallel(t *testing.T) {
+	paths := []string{"user/user.proto", "common/all.proto", "internal/secret.proto"}
+
+	seq := NewFileSet(testlinkset)
+	if err := seq.Load(paths...); err != nil {
+		t.Fatalf("Error loading proto files: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		par := NewFileSet(testlinkset)
+		if err := par.LoadParallel(context.Background(), 4, paths...); err != nil {
+			t.Fatalf("Error loading proto files in parallel: %v", err)
+		}
+
+		if len(par.Files) != len(seq.Files) {
+			t.Fatalf("Expected %d files, got %d", len(seq.Files), len(par.Files))
+		}
+		for fi, f := range par.Files {
+			if f.FileName != seq.Files[fi].FileName {
+				t.Fatalf("File %d should be '%s', got '%s'", fi, seq.Files[fi].FileName, f.FileName)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	par := NewFileSet(testlinkset)
+	if err := par.LoadParallel(ctx, 4, paths...); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled error, got %v", err)
+	}
+	if len(par.Files) != 0 {
+		t.Fatalf("Cancelled load should not add files")
+	}
+}
+
+func TestFileSetReload(t *testing.T) {
+	source := MapImportSource{}
+	for k, v := range testlinkset {
+		source[k] = v
+	}
+
+	cache := NewParseCache()
+	fs := NewFileSet(source)
+	fs.Cache = cache
+	if err := fs.Load("user/user.proto", "internal/secret.proto"); err != nil {
+		t.Fatalf("Error loading proto files: %v", err)
+	}
+	links, _ := Link(fs)
+
+	// files not loaded can't be reloaded
+	if _, err := fs.Reload("common/unused.proto"); err == nil {
+		t.Fatalf("Reloading a file not loaded should be an error")
+	} else if _, ok := err.(*FileNotLoaded); !ok {
+		t.Fatalf("Expected a FileNotLoaded error, got %v", err)
+	}
+
+	// unchanged files are not reparsed
+	affected, err := fs.Reload("common/money.proto", "user/user.proto")
+	if err != nil {
+		t.Fatalf("Error reloading proto files: %v", err)
+	}
+	if len(affected) != 0 {
+		t.Fatalf("No files should be affected, got %d", len(affected))
+	}
+
+	source["common/money.proto"] = `
+syntax = "proto3";
+package common;
+message Money {
+	string currency = 1;
+	int64 units = 2;
+	int32 nanos = 3;
+}
+`
+	affected, err = fs.Reload("common/money.proto")
+	if err != nil {
+		t.Fatalf("Error reloading proto files: %v", err)
+	}
+
+	var names []string
+	for _, f := range affected {
+		names = append(names, f.FileName)
+	}
+	if strings.Join(names, ",") != "common/money.proto,common/all.proto,user/user.proto" {
+		t.Fatalf("Unexpected affected files: %v", names)
+	}
+
+	links.Relink(affected...)
+
+	user := fs.FindFile("user/user.proto").FindName("User")[0].(*MessageElement)
+	money := links.FieldType(user.FindField("balance").(*FieldElement)).(*MessageElement)
+	if len(money.Fields) != 3 {
+		t.Fatalf("Field 'balance' should be linked to the reloaded common.Money")
+	}
+
+	stats := cache.Stats()
+	if stats.Misses != 7 || stats.Hits != 0 {
+		t.Fatalf("Expected 7 cache misses and 0 hits, got %d and %d", stats.Misses, stats.Hits)
+	}
+
+	// a new FileSet sharing the cache doesn't parse again
+	fs2 := NewFileSet(source)
+	fs2.Cache = cache
+	if err := fs2.Load("user/user.proto"); err != nil {
+		t.Fatalf("Error loading proto files: %v", err)
+	}
+	if stats := cache.Stats(); stats.Hits != 6 {
+		t.Fatalf("Expected 6 cache hits, got %d", stats.Hits)
+	}
+
+	// the files are not shared between the FileSets
+	user2 := fs2.FindFile("user/user.proto").FindName("User")[0].(*MessageElement)
+	if user2 == user || user2.Parent != fs2.FindFile("user/user.proto") || user2.FindField("balance").ParentElement() != user2 {
+		t.Fatalf("Cached files should be copied with their parents")
+	}
+	user2.Name = "Changed"
+	if user.Name != "User" {
+		t.Fatalf("Changing a cached file should not change the other FileSets")
+	}
+}
@@ -0,0 +1,675 @@
+package fproto
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"text/scanner"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Builds a ProtoFile from a file descriptor, like the ones generated by protoc.
+//
+// Type names are kept as in the descriptor, usually fully qualified. Map entry
+// messages become map fields, and synthetic oneofs of proto3 optional fields
+// are removed. Comments are read from the source code info, if available.
+func FromFileDescriptorProto(fd *descriptorpb.FileDescriptorProto) (*ProtoFile, error) {
+	return fromFileDescriptorProto(fd, descriptorExtensionTypes(&descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{fd},
+	}))
+}
+
+// Builds ProtoFiles from all the files of the descriptor set, in the same order.
+//
+// Custom options already interpreted by protoc are stored as extension fields
+// of the options messages. The extensions defined in the set are used to read
+// them back; options of extensions not in the set are dropped.
+func FromFileDescriptorSet(set *descriptorpb.FileDescriptorSet) ([]*ProtoFile, error) {
+	types := descriptorExtensionTypes(set)
+	var ret []*ProtoFile
+	for _, fd := range set.File {
+		f, err := fromFileDescriptorProto(fd, types)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, f)
+	}
+	return ret, nil
+}
+
+func fromFileDescriptorProto(fd *descriptorpb.FileDescriptorProto, types protoregistry.ExtensionTypeResolver) (*ProtoFile, error) {
+	b := &elementBuilder{
+		fd:       fd,
+		types:    types,
+		comments: make(map[string]*descriptorpb.SourceCodeInfo_Location),
+	}
+	for _, loc := range fd.GetSourceCodeInfo().GetLocation() {
+		b.comments[pathKey(loc.Path)] = loc
+	}
+	return b.buildFile()
+}
+
+// Returns the extension types defined in the descriptor set, or nil if the set
+// is not valid. Imports missing from the set are allowed.
+func descriptorExtensionTypes(set *descriptorpb.FileDescriptorSet) protoregistry.ExtensionTypeResolver {
+	files, err := protodesc.FileOptions{AllowUnresolvable: true}.NewFiles(set)
+	if err != nil {
+		return nil
+	}
+	return dynamicpb.NewTypes(files)
+}
+
+// Creates a new FileSet with all the files of the descriptor set loaded.
+// Imports not in the descriptor set are loaded from the import sources.
+//
+// The source contents of the files are not available.
+func NewFileSetFromDescriptorSet(set *descriptorpb.FileDescriptorSet, sources ...ImportSource) (*FileSet, error) {
+	files, err := FromFileDescriptorSet(set)
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewFileSet(sources...)
+	parsed := make(map[string]*parsedFile)
+	var roots []string
+	for _, f := range files {
+		parsed[f.FileName] = &parsedFile{file: f, location: "descriptor:" + f.FileName}
+		roots = append(roots, f.FileName)
+	}
+	if err := s.rebuild(parsed, roots); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reads a descriptor set in the binary .protoset format, like "protoc --descriptor_set_out" output.
+func ReadProtoset(r io.Reader) (*descriptorpb.FileDescriptorSet, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	ret := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Reads a descriptor set from a .protoset file.
+func ReadProtosetFile(fn string) (*descriptorpb.FileDescriptorSet, error) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	ret := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+type elementBuilder struct {
+	fd       *descriptorpb.FileDescriptorProto
+	types    protoregistry.ExtensionTypeResolver
+	comments map[string]*descriptorpb.SourceCodeInfo_Location
+}
+
+func (b *elementBuilder) buildFile() (*ProtoFile, error) {
+	fd := b.fd
+	ret := &ProtoFile{
+		FileName:    fd.GetName(),
+		PackageName: fd.GetPackage(),
+		Syntax:      fd.GetSyntax(),
+	}
+
+	weak := make(map[int32]bool)
+	for _, i := range fd.WeakDependency {
+		weak[i] = true
+	}
+	for i, dep := range fd.Dependency {
+		if weak[int32(i)] {
+			ret.WeakDependencies = append(ret.WeakDependencies, dep)
+		} else {
+			ret.Dependencies = append(ret.Dependencies, dep)
+		}
+	}
+	for _, i := range fd.PublicDependency {
+		if i < 0 || int(i) >= len(fd.Dependency) {
+			return nil, b.errorf("public dependency index %d out of range", i)
+		}
+		ret.PublicDependencies = append(ret.PublicDependencies, fd.Dependency[i])
+	}
+
+	ret.Options = b.buildOptions(ret, fd.Options)
+
+	for i, md := range fd.MessageType {
+		m, err := b.buildMessage(ret, md, []int32{4, int32(i)})
+		if err != nil {
+			return nil, err
+		}
+		ret.Messages = append(ret.Messages, m)
+	}
+	for i, ed := range fd.EnumType {
+		ret.Enums = append(ret.Enums, b.buildEnum(ret, ed, []int32{5, int32(i)}))
+	}
+	for i, sd := range fd.Service {
+		ret.Services = append(ret.Services, b.buildService(ret, sd, []int32{6, int32(i)}))
+	}
+	ret.ExtendMessages = b.buildExtends(ret, fd.Extension, []int32{7})
+
+	return ret, nil
+}
+
+func (b *elementBuilder) buildMessage(parent FProtoElement, md *descriptorpb.DescriptorProto, path []int32) (*MessageElement, error) {
+	ret := &MessageElement{
+		Parent:        parent,
+		Name:          md.GetName(),
+		Comment:       b.comment(path),
+		ReservedNames: md.ReservedName,
+	}
+	ret.Options = b.buildOptions(ret, md.Options)
+
+	// map entries are not nested messages in the source
+	mapEntries := make(map[string]*descriptorpb.DescriptorProto)
+	for i, nd := range md.NestedType {
+		if nd.GetOptions().GetMapEntry() {
+			mapEntries[nd.GetName()] = nd
+			continue
+		}
+		m, err := b.buildMessage(ret, nd, appendPath(path, 3, i))
+		if err != nil {
+			return nil, err
+		}
+		ret.Messages = append(ret.Messages, m)
+	}
+	for i, ed := range md.EnumType {
+		ret.Enums = append(ret.Enums, b.buildEnum(ret, ed, appendPath(path, 4, i)))
+	}
+
+	// oneofs used only by a proto3 optional field are synthetic
+	oneofFields := make(map[int32]int)
+	for _, fd := range md.Field {
+		if fd.OneofIndex != nil {
+			oneofFields[fd.GetOneofIndex()]++
+		}
+	}
+	oneofs := make(map[int32]*OneOfFieldElement)
+
+	for i, fd := range md.Field {
+		fpath := appendPath(path, 2, i)
+
+		if entry := b.mapEntry(md, fd, mapEntries); entry != nil {
+			mf := &MapFieldElement{
+				Parent: ret,
+			}
+			mf.FieldElement = b.buildField(ret, mf, fd, fpath)
+			if len(entry.Field) != 2 {
+				return nil, b.errorf("map entry '%s' must have 2 fields", entry.GetName())
+			}
+			mf.KeyType = descriptorTypeName(entry.Field[0])
+			mf.Type = descriptorTypeName(entry.Field[1])
+			mf.Repeated = false
+			ret.Fields = append(ret.Fields, mf)
+			continue
+		}
+
+		if fd.OneofIndex == nil || (fd.GetProto3Optional() && oneofFields[fd.GetOneofIndex()] == 1) {
+			ret.Fields = append(ret.Fields, b.buildField(ret, nil, fd, fpath))
+			continue
+		}
+
+		idx := fd.GetOneofIndex()
+		if idx < 0 || int(idx) >= len(md.OneofDecl) {
+			return nil, b.errorf("oneof index %d of field '%s' out of range", idx, fd.GetName())
+		}
+		oneof, ok := oneofs[idx]
+		if !ok {
+			od := md.OneofDecl[idx]
+			oneof = &OneOfFieldElement{
+				Parent:  ret,
+				Name:    od.GetName(),
+				Comment: b.comment(appendPath(path, 8, int(idx))),
+			}
+			oneof.Options = b.buildOptions(oneof, od.Options)
+			oneofs[idx] = oneof
+			ret.Fields = append(ret.Fields, oneof)
+		}
+		oneof.Fields = append(oneof.Fields, b.buildField(oneof, nil, fd, fpath))
+	}
+
+	for i, er := range md.ExtensionRange {
+		e := &ExtensionsElement{
+			Parent:  ret,
+			Comment: b.comment(appendPath(path, 5, i)),
+		}
+		e.Start, e.End, e.IsMax = descriptorRange(er.GetStart(), er.GetEnd())
+		ret.Extensions = append(ret.Extensions, e)
+	}
+	for i, rr := range md.ReservedRange {
+		r := &ReservedRangeElement{
+			Parent:  ret,
+			Comment: b.comment(appendPath(path, 9, i)),
+		}
+		r.Start, r.End, r.IsMax = descriptorRange(rr.GetStart(), rr.GetEnd())
+		ret.ReservedRanges = append(ret.ReservedRanges, r)
+	}
+
+	ret.ExtendMessages = b.buildExtends(ret, md.Extension, appendPath(path, 6))
+
+	return ret, nil
+}
+
+// Returns the map entry message of the field, if it is a map field.
+func (b *elementBuilder) mapEntry(md *descriptorpb.DescriptorProto, fd *descriptorpb.FieldDescriptorProto,
+	mapEntries map[string]*descriptorpb.DescriptorProto) *descriptorpb.DescriptorProto {
+	if fd.GetLabel() != descriptorpb.FieldDescriptorProto_LABEL_REPEATED ||
+		fd.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE && fd.Type != nil {
+		return nil
+	}
+	typeName := fd.GetTypeName()
+	entryName := typeName
+	if p := strings.LastIndex(typeName, "."); p >= 0 {
+		entryName = typeName[p+1:]
+	}
+	entry, ok := mapEntries[entryName]
+	if !ok {
+		return nil
+	}
+	if typeName != entryName && !strings.HasSuffix(typeName, "."+md.GetName()+"."+entryName) {
+		return nil
+	}
+	return entry
+}
+
+// Groups the extension fields by extendee, in order.
+func (b *elementBuilder) buildExtends(parent FProtoElement, fields []*descriptorpb.FieldDescriptorProto, path []int32) []*MessageElement {
+	var ret []*MessageElement
+	extends := make(map[string]*MessageElement)
+	for i, fd := range fields {
+		ext, ok := extends[fd.GetExtendee()]
+		if !ok {
+			ext = &MessageElement{
+				Parent:   parent,
+				Name:     fd.GetExtendee(),
+				IsExtend: true,
+			}
+			extends[fd.GetExtendee()] = ext
+			ret = append(ret, ext)
+		}
+		ext.Fields = append(ext.Fields, b.buildField(ext, nil, fd, appendPath(path, i)))
+	}
+	return ret
+}
+
+// Builds the field. For map fields, optionParent is the MapFieldElement.
+func (b *elementBuilder) buildField(parent, optionParent FProtoElement, fd *descriptorpb.FieldDescriptorProto, path []int32) *FieldElement {
+	ret := &FieldElement{
+		Parent:  parent,
+		Name:    fd.GetName(),
+		Comment: b.comment(path),
+		Type:    descriptorTypeName(fd),
+		Tag:     int(fd.GetNumber()),
+	}
+	if optionParent == nil {
+		optionParent = ret
+	}
+
+	switch fd.GetLabel() {
+	case descriptorpb.FieldDescriptorProto_LABEL_REPEATED:
+		ret.Repeated = true
+	case descriptorpb.FieldDescriptorProto_LABEL_REQUIRED:
+		ret.Required = true
+	case descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL:
+		if b.fd.GetSyntax() == "proto3" {
+			ret.Optional = fd.GetProto3Optional()
+		} else {
+			// the label is always written in proto2, except in oneofs
+			ret.Optional = fd.OneofIndex == nil
+		}
+	}
+
+	if fd.DefaultValue != nil {
+		isString := fd.GetType() == descriptorpb.FieldDescriptorProto_TYPE_STRING ||
+			fd.GetType() == descriptorpb.FieldDescriptorProto_TYPE_BYTES
+		ret.Options = append(ret.Options, newOptionElement(optionParent, "default", &Literal{
+			Source:   fd.GetDefaultValue(),
+			IsString: isString,
+		}))
+	}
+	if fd.JsonName != nil && fd.GetJsonName() != JSONName(fd.GetName()) {
+		ret.Options = append(ret.Options, newOptionElement(optionParent, "json_name", &Literal{
+			Source:   fd.GetJsonName(),
+			IsString: true,
+		}))
+	}
+	ret.Options = append(ret.Options, b.buildOptions(optionParent, fd.Options)...)
+
+	return ret
+}
+
+func (b *elementBuilder) buildEnum(parent FProtoElement, ed *descriptorpb.EnumDescriptorProto, path []int32) *EnumElement {
+	ret := &EnumElement{
+		Parent:  parent,
+		Name:    ed.GetName(),
+		Comment: b.comment(path),
+	}
+	ret.Options = b.buildOptions(ret, ed.Options)
+	for i, vd := range ed.Value {
+		c := &EnumConstantElement{
+			Parent:  ret,
+			Name:    vd.GetName(),
+			Comment: b.comment(appendPath(path, 2, i)),
+			Tag:     int(vd.GetNumber()),
+		}
+		c.Options = b.buildOptions(c, vd.Options)
+		ret.EnumConstants = append(ret.EnumConstants, c)
+	}
+	return ret
+}
+
+func (b *elementBuilder) buildService(parent FProtoElement, sd *descriptorpb.ServiceDescriptorProto, path []int32) *ServiceElement {
+	ret := &ServiceElement{
+		Parent:  parent,
+		Name:    sd.GetName(),
+		Comment: b.comment(path),
+	}
+	ret.Options = b.buildOptions(ret, sd.Options)
+	for i, md := range sd.Method {
+		rpc := &RPCElement{
+			Parent:          ret,
+			Name:            md.GetName(),
+			Comment:         b.comment(appendPath(path, 2, i)),
+			RequestType:     md.GetInputType(),
+			StreamsRequest:  md.GetClientStreaming(),
+			ResponseType:    md.GetOutputType(),
+			StreamsResponse: md.GetServerStreaming(),
+		}
+		rpc.Options = b.buildOptions(rpc, md.Options)
+		ret.RPCs = append(ret.RPCs, rpc)
+	}
+	return ret
+}
+
+// Converts the fields set on the options message, by field number, followed
+// by the uninterpreted options.
+func (b *elementBuilder) buildOptions(parent FProtoElement, opts proto.Message) []*OptionElement {
+	if opts == nil || !opts.ProtoReflect().IsValid() {
+		return nil
+	}
+	m := b.resolveExtensions(opts.ProtoReflect())
+
+	var fields []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		fields = append(fields, fd)
+		return true
+	})
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Number() < fields[j].Number()
+	})
+
+	var ret []*OptionElement
+	var uninterpreted protoreflect.List
+	for _, fd := range fields {
+		switch {
+		case fd.Name() == "uninterpreted_option" && !fd.IsExtension():
+			uninterpreted = m.Get(fd).List()
+			continue
+		case fd.Name() == "map_entry" && !fd.IsExtension():
+			// generated by the compiler
+			continue
+		}
+
+		o := newOptionElement(parent, string(fd.Name()), nil)
+		if fd.IsExtension() {
+			o.Name = string(fd.FullName())
+			o.ParenthesizedName = o.Name
+			o.IsParenthesized = true
+		}
+
+		v := m.Get(fd)
+		switch {
+		case fd.IsList():
+			o.Value = &Literal{}
+			for i := 0; i < v.List().Len(); i++ {
+				o.Value.Array = append(o.Value.Array, valueLiteral(fd, v.List().Get(i)))
+			}
+		case fd.Message() != nil:
+			o.Value = &Literal{}
+			o.AggregatedValues = make(map[string]*Literal)
+			v.Message().Range(func(sfd protoreflect.FieldDescriptor, sv protoreflect.Value) bool {
+				if !sfd.IsList() && !sfd.IsMap() && sfd.Message() == nil {
+					o.AggregatedValues[string(sfd.Name())] = valueLiteral(sfd, sv)
+				}
+				return true
+			})
+		default:
+			o.Value = valueLiteral(fd, v)
+		}
+		ret = append(ret, o)
+	}
+
+	if uninterpreted != nil {
+		for i := 0; i < uninterpreted.Len(); i++ {
+			uo := uninterpreted.Get(i).Message().Interface().(*descriptorpb.UninterpretedOption)
+			ret = append(ret, interpretedOption(parent, uo))
+		}
+	}
+
+	return ret
+}
+
+// Options interpreted by protoc are unknown fields of the options message, as
+// the extensions are not linked in. Returns the message with the unknown fields
+// read again using the extension types, or the same message if not possible.
+func (b *elementBuilder) resolveExtensions(m protoreflect.Message) protoreflect.Message {
+	if b.types == nil || len(m.GetUnknown()) == 0 {
+		return m
+	}
+	data, err := proto.Marshal(m.Interface())
+	if err != nil {
+		return m
+	}
+	ret := m.New()
+	if err := (proto.UnmarshalOptions{Resolver: b.types}).Unmarshal(data, ret.Interface()); err != nil {
+		return m
+	}
+	return ret
+}
+
+// Returns the location comments, leading ones if available.
+func (b *elementBuilder) comment(path []int32) *Comment {
+	loc, ok := b.comments[pathKey(path)]
+	if !ok {
+		return nil
+	}
+	text := loc.GetLeadingComments()
+	if text == "" {
+		text = loc.GetTrailingComments()
+	}
+	if text == "" {
+		return nil
+	}
+
+	ret := &Comment{}
+	for _, ln := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		// protoc keeps all the text after the comment marker, so only the usual
+		// single space is removed, keeping the indentation
+		ret.Lines = append(ret.Lines, strings.TrimPrefix(ln, " "))
+	}
+	return ret
+}
+
+func (b *elementBuilder) errorf(format string, args ...interface{}) error {
+	return &InvalidDescriptor{File: b.fd.GetName(), Reason: fmt.Sprintf(format, args...)}
+}
+
+func newOptionElement(parent FProtoElement, name string, value *Literal) *OptionElement {
+	return &OptionElement{
+		Parent:            parent,
+		Name:              name,
+		ParenthesizedName: name,
+		Value:             value,
+	}
+}
+
+// Option as the parser would have returned it.
+func interpretedOption(parent FProtoElement, uo *descriptorpb.UninterpretedOption) *OptionElement {
+	var parts []string
+	for _, np := range uo.Name {
+		parts = append(parts, np.GetNamePart())
+	}
+	o := newOptionElement(parent, strings.Join(parts, "."), &Literal{})
+	if len(uo.Name) > 0 && uo.Name[0].GetIsExtension() {
+		o.IsParenthesized = true
+		o.ParenthesizedName = parts[0]
+		o.NPName = strings.Join(parts[1:], ".")
+	}
+
+	switch {
+	case uo.IdentifierValue != nil:
+		o.Value.Source = uo.GetIdentifierValue()
+	case uo.PositiveIntValue != nil:
+		o.Value.Source = strconv.FormatUint(uo.GetPositiveIntValue(), 10)
+	case uo.NegativeIntValue != nil:
+		o.Value.Source = strconv.FormatInt(uo.GetNegativeIntValue(), 10)
+	case uo.DoubleValue != nil:
+		o.Value.Source = strconv.FormatFloat(uo.GetDoubleValue(), 'g', -1, 64)
+	case uo.StringValue != nil:
+		o.Value.Source = string(uo.StringValue)
+		o.Value.IsString = true
+	case uo.AggregateValue != nil:
+		o.AggregatedValues = parseAggregate(uo.GetAggregateValue())
+	}
+
+	// the parser adds the sub option name as an aggregated value
+	if o.IsParenthesized && o.NPName != "" {
+		if o.AggregatedValues == nil {
+			o.AggregatedValues = make(map[string]*Literal)
+		}
+		o.AggregatedValues[o.NPName] = o.Value
+	}
+	return o
+}
+
+// Parses the "name: value" pairs of an aggregate option value. Nested
+// messages are kept as source.
+func parseAggregate(src string) map[string]*Literal {
+	ret := make(map[string]*Literal)
+
+	var s scanner.Scanner
+	s.Init(strings.NewReader(src))
+	s.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanFloats | scanner.ScanStrings
+	s.Error = func(*scanner.Scanner, string) {}
+
+	var value func(tok rune) *Literal
+	value = func(tok rune) *Literal {
+		switch tok {
+		case scanner.String:
+			str, err := strconv.Unquote(s.TokenText())
+			if err != nil {
+				str = s.TokenText()
+			}
+			return &Literal{Source: str, IsString: true}
+		case '-':
+			s.Scan()
+			return &Literal{Source: "-" + s.TokenText()}
+		case '[':
+			ret := &Literal{Array: []*Literal{}}
+			for tok := s.Scan(); tok != ']' && tok != scanner.EOF; tok = s.Scan() {
+				if tok != ',' {
+					ret.Array = append(ret.Array, value(tok))
+				}
+			}
+			return ret
+		case '{':
+			start := s.Position.Offset
+			depth := 1
+			for depth > 0 {
+				switch s.Scan() {
+				case '{':
+					depth++
+				case '}':
+					depth--
+				case scanner.EOF:
+					depth = 0
+				}
+			}
+			return &Literal{Source: "{" + src[start+1:s.Position.Offset] + "}"}
+		default:
+			return &Literal{Source: s.TokenText()}
+		}
+	}
+
+	for tok := s.Scan(); tok != scanner.EOF; tok = s.Scan() {
+		if tok != scanner.Ident {
+			continue
+		}
+		name := s.TokenText()
+		tok = s.Scan()
+		if tok == ':' {
+			tok = s.Scan()
+		}
+		if tok == scanner.EOF {
+			break
+		}
+		ret[name] = value(tok)
+	}
+	return ret
+}
+
+// Literal of a scalar or enum option value.
+func valueLiteral(fd protoreflect.FieldDescriptor, v protoreflect.Value) *Literal {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return &Literal{Source: v.String(), IsString: true}
+	case protoreflect.BytesKind:
+		return &Literal{Source: string(v.Bytes()), IsString: true}
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return &Literal{Source: string(ev.Name())}
+		}
+		return &Literal{Source: strconv.Itoa(int(v.Enum()))}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return &Literal{Source: strconv.FormatFloat(v.Float(), 'g', -1, 64)}
+	default:
+		return &Literal{Source: v.String()}
+	}
+}
+
+// Returns the field type as written in the source.
+func descriptorTypeName(fd *descriptorpb.FieldDescriptorProto) string {
+	for st, t := range scalarDescriptorTypes {
+		if fd.Type != nil && t == fd.GetType() {
+			return st.ProtoType()
+		}
+	}
+	return fd.GetTypeName()
+}
+
+// Converts a descriptor range, with exclusive end.
+func descriptorRange(start, end int32) (int, int, bool) {
+	if end >= descriptorMaxFieldNumber {
+		return int(start), 0, true
+	}
+	return int(start), int(end - 1), false
+}
+
+func pathKey(path []int32) string {
+	var b strings.Builder
+	for i, p := range path {
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(strconv.Itoa(int(p)))
+	}
+	return b.String()
+}
@@ -0,0 +1,415 @@
+package fproto
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// GoModImportSource finds .proto files inside the Go modules required by a
+// go.mod file, using the local module cache or the vendor directory.
+// It never accesses the network; modules not found locally are ignored.
+//
+// An import path is found in a module when a .proto file in it has the import
+// path as its path suffix, so "google/api/annotations.proto" is found at
+// "third_party/googleapis/google/api/annotations.proto". Exact matches are
+// preferred, then the shortest path relative to the module root, then the
+// go.mod order.
+//
+// The builtin google/protobuf files are never searched in modules, as many
+// modules carry their own, possibly incompatible, copies.
+type GoModImportSource struct {
+	// Directory containing go.mod and go.sum
+	ModDir string
+	// Module cache directory. Defaults to GOMODCACHE, or GOPATH/pkg/mod.
+	CacheDir string
+	// Required modules, in go.mod order followed by the ones only listed in go.sum.
+	Modules []*GoModule
+
+	once  sync.Once
+	index map[string][]*goModFile
+}
+
+// A .proto file of a module
+type goModFile struct {
+	module int    // index in Modules
+	rel    string // slash separated path relative to the module root
+	fn     string
+}
+
+// GoModule is a module version required by the go.mod file.
+type GoModule struct {
+	Path    string
+	Version string
+	// Local directory of the module contents. Empty if not available locally.
+	Dir string
+}
+
+// Creates an import source for the modules required by the go.mod file at modDir.
+func NewGoModImportSource(modDir string) (*GoModImportSource, error) {
+	ret := &GoModImportSource{
+		ModDir:   modDir,
+		CacheDir: goModCacheDir(),
+	}
+
+	req, replace, err := parseGoMod(filepath.Join(modDir, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+	sum, err := parseGoSum(filepath.Join(modDir, "go.sum"))
+	if err != nil {
+		return nil, err
+	}
+
+	// modules only listed in go.sum, like indirect dependencies of older go.mod files
+	required := make(map[string]bool)
+	for _, m := range req {
+		required[m.Path] = true
+	}
+	for _, m := range sum {
+		if !required[m.Path] {
+			req = append(req, m)
+		}
+	}
+
+	vendorDir := filepath.Join(modDir, "vendor")
+	hasVendor := isRegularFile(filepath.Join(vendorDir, "modules.txt"))
+
+	for _, m := range req {
+		if r, ok := replace[m.Path]; ok {
+			if r.Version == "" {
+				// local directory replacement
+				dir := r.Path
+				if !filepath.IsAbs(dir) {
+					dir = filepath.Join(modDir, dir)
+				}
+				ret.Modules = append(ret.Modules, &GoModule{Path: m.Path, Version: m.Version, Dir: dir})
+				continue
+			}
+			m = &GoModule{Path: r.Path, Version: r.Version}
+		}
+
+		if hasVendor {
+			if dir := filepath.Join(vendorDir, filepath.FromSlash(m.Path)); isDir(dir) {
+				m.Dir = dir
+			}
+		}
+		if m.Dir == "" && ret.CacheDir != "" {
+			if dir := filepath.Join(ret.CacheDir, filepath.FromSlash(escapeModulePath(m.Path)+"@"+escapeModulePath(m.Version))); isDir(dir) {
+				m.Dir = dir
+			}
+		}
+		ret.Modules = append(ret.Modules, m)
+	}
+
+	return ret, nil
+}
+
+func (s *GoModImportSource) OpenImport(importPath string) (io.ReadCloser, string, error) {
+	if !IsValidImportPath(importPath) {
+		return nil, "", &InvalidImportPath{Path: importPath}
+	}
+
+	if IsBuiltinImport(importPath) {
+		return nil, "", &ImportNotFound{Path: importPath}
+	}
+
+	found := s.Locate(importPath)
+	if len(found) == 0 {
+		return nil, "", &ImportNotFound{Path: importPath}
+	}
+
+	f, err := os.Open(found[0])
+	if err != nil {
+		return nil, "", err
+	}
+	return f, found[0], nil
+}
+
+// Returns all the module files matching the import path, the first one being
+// the one that is used.
+func (s *GoModImportSource) Locate(importPath string) []string {
+	s.once.Do(s.buildIndex)
+
+	base := importPath
+	if i := strings.LastIndex(base, "/"); i >= 0 {
+		base = base[i+1:]
+	}
+	var found []*goModFile
+	for _, f := range s.index[base] {
+		if f.rel == importPath || strings.HasSuffix(f.rel, "/"+importPath) {
+			found = append(found, f)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		iexact, jexact := found[i].rel == importPath, found[j].rel == importPath
+		if iexact != jexact {
+			return iexact
+		}
+		if len(found[i].rel) != len(found[j].rel) {
+			return len(found[i].rel) < len(found[j].rel)
+		}
+		return found[i].module < found[j].module
+	})
+
+	var ret []string
+	for _, f := range found {
+		ret = append(ret, f.fn)
+	}
+	return ret
+}
+
+// index all .proto files of the modules by their base name
+func (s *GoModImportSource) buildIndex() {
+	s.index = make(map[string][]*goModFile)
+	for i, m := range s.Modules {
+		if m.Dir == "" {
+			continue
+		}
+		filepath.Walk(m.Dir, func(fn string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() {
+				if fn != m.Dir && (info.Name() == "testdata" || strings.HasPrefix(info.Name(), ".")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(info.Name(), ".proto") {
+				rel, err := filepath.Rel(m.Dir, fn)
+				if err != nil {
+					return nil
+				}
+				s.index[info.Name()] = append(s.index[info.Name()], &goModFile{
+					module: i,
+					rel:    filepath.ToSlash(rel),
+					fn:     fn,
+				})
+			}
+			return nil
+		})
+	}
+}
+
+func goModCacheDir() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	if out, err := exec.Command("go", "env", "GOMODCACHE").Output(); err == nil {
+		if dir := strings.TrimSpace(string(out)); dir != "" {
+			return dir
+		}
+	}
+	gopath := os.Getenv("GOPATH")
+	if gopath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		gopath = filepath.Join(home, "go")
+	}
+	return filepath.Join(filepath.SplitList(gopath)[0], "pkg", "mod")
+}
+
+// Escapes the module path or version like the module cache does: uppercase
+// letters are replaced by "!" followed by the lowercase letter.
+func escapeModulePath(p string) string {
+	var b strings.Builder
+	for _, r := range p {
+		if unicode.IsUpper(r) {
+			b.WriteRune('!')
+			b.WriteRune(unicode.ToLower(r))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+//
+// go.mod / go.sum parsing
+//
+
+func parseGoMod(fn string) (require []*GoModule, replace map[string]*GoModule, err error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	replace = make(map[string]*GoModule)
+
+	block := ""
+	scanner := bufio.NewScanner(f)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		tokens, err := goModTokens(line)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %s", fn, lineno, err.Error())
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+
+		directive := block
+		if block != "" {
+			if tokens[0] == ")" {
+				block = ""
+				continue
+			}
+		} else {
+			if len(tokens) == 2 && tokens[1] == "(" {
+				block = tokens[0]
+				continue
+			}
+			directive, tokens = tokens[0], tokens[1:]
+		}
+
+		switch directive {
+		case "require":
+			if len(tokens) < 2 {
+				return nil, nil, fmt.Errorf("%s:%d: invalid require", fn, lineno)
+			}
+			require = append(require, &GoModule{Path: tokens[0], Version: tokens[1]})
+		case "replace":
+			arrow := -1
+			for i, t := range tokens {
+				if t == "=>" {
+					arrow = i
+				}
+			}
+			if arrow < 1 || arrow == len(tokens)-1 {
+				return nil, nil, fmt.Errorf("%s:%d: invalid replace", fn, lineno)
+			}
+			r := &GoModule{Path: tokens[arrow+1]}
+			if len(tokens) > arrow+2 {
+				r.Version = tokens[arrow+2]
+			}
+			replace[tokens[0]] = r
+		}
+	}
+
+	return require, replace, scanner.Err()
+}
+
+// Splits a go.mod line into tokens, unquoting quoted strings.
+func goModTokens(line string) ([]string, error) {
+	var ret []string
+	for {
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		if line == "" {
+			return ret, nil
+		}
+		if line[0] == '"' || line[0] == '`' {
+			end := strings.IndexByte(line[1:], line[0])
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			t, err := strconv.Unquote(line[:end+2])
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, t)
+			line = line[end+2:]
+			continue
+		}
+		end := strings.IndexFunc(line, unicode.IsSpace)
+		if end < 0 {
+			end = len(line)
+		}
+		ret = append(ret, line[:end])
+		line = line[end:]
+	}
+}
+
+// Returns the modules with downloaded contents listed in the go.sum file, using
+// the highest version of each. A missing go.sum is not an error.
+func parseGoSum(fn string) ([]*GoModule, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var ret []*GoModule
+	modules := make(map[string]*GoModule)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || strings.HasSuffix(fields[1], "/go.mod") {
+			continue
+		}
+		if m, ok := modules[fields[0]]; ok {
+			if compareSemver(fields[1], m.Version) > 0 {
+				m.Version = fields[1]
+			}
+			continue
+		}
+		m := &GoModule{Path: fields[0], Version: fields[1]}
+		modules[m.Path] = m
+		ret = append(ret, m)
+	}
+	return ret, scanner.Err()
+}
+
+// Compares two semantic versions like "v1.2.3" or "v1.2.3-pre", returning -1, 0 or 1.
+func compareSemver(a, b string) int {
+	amain, apre := splitSemver(a)
+	bmain, bpre := splitSemver(b)
+	for i := 0; i < 3; i++ {
+		if amain[i] != bmain[i] {
+			if amain[i] < bmain[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case apre == bpre:
+		return 0
+	case apre == "":
+		return 1
+	case bpre == "":
+		return -1
+	case apre < bpre:
+		return -1
+	}
+	return 1
+}
+
+func splitSemver(v string) (main [3]int, pre string) {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i]
+	}
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		v, pre = v[:i], v[i+1:]
+	}
+	for i, p := range strings.SplitN(v, ".", 3) {
+		main[i], _ = strconv.Atoi(p)
+	}
+	return main, pre
+}
+
+func isDir(fn string) bool {
+	st, err := os.Stat(fn)
+	return err == nil && st.IsDir()
+}
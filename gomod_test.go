@@ -0,0 +1,101 @@
+package fproto
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		fn := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fn, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGoModImportSource(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("GOMODCACHE", cache)
+	writeTestFiles(t, cache, map[string]string{
+		"github.com/verylongorganizationname/verylongrepositoryname@v1.2.0/google/api/annotations.proto": "long",
+		"github.com/verylongorganizationname/verylongrepositoryname@v1.2.0/common/common.proto":          "long common",
+		"github.com/a/b@v1.0.0/third_party/google/api/annotations.proto":                                 "ab",
+		"github.com/a/b@v1.0.0/common/common.proto":                                                      "ab common",
+		"github.com/a/b@v1.0.0/google/protobuf/empty.proto":                                              "ab empty",
+		"github.com/!upper/mod@v0.1.0/up/upper.proto":                                                    "upper",
+		"example.com/vend@v1.0.0/vend/vend.proto":                                                        "cached vend",
+	})
+
+	modDir := t.TempDir()
+	writeTestFiles(t, modDir, map[string]string{
+		"go.mod": `module example.com/app
+
+go 1.21
+
+require (
+	github.com/verylongorganizationname/verylongrepositoryname v1.2.0
+	github.com/a/b v1.0.0
+	github.com/Upper/mod v0.1.0
+	example.com/local v0.0.0
+	example.com/vend v1.0.0
+)
+
+replace example.com/local => ./local
+`,
+		"local/loc/local.proto":                   "local",
+		"vendor/modules.txt":                      "# example.com/vend v1.0.0\n",
+		"vendor/example.com/vend/vend/vend.proto": "vendored vend",
+	})
+
+	src, err := NewGoModImportSource(modDir)
+	if err != nil {
+		t.Fatalf("Error creating import source: %v", err)
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		// exact match over a suffix match in a module with a shorter cache path
+		{"google/api/annotations.proto", "long"},
+		// shortest relative path
+		{"api/annotations.proto", "long"},
+		// go.mod order
+		{"common/common.proto", "long common"},
+		{"up/upper.proto", "upper"},
+		{"loc/local.proto", "local"},
+		// vendor directory over the module cache
+		{"vend/vend.proto", "vendored vend"},
+	}
+	for _, test := range tests {
+		r, _, err := src.OpenImport(test.path)
+		if err != nil {
+			t.Fatalf("Error opening %s: %v", test.path, err)
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != test.expected {
+			t.Fatalf("Wrong file for %s: %s, expected %s", test.path, data, test.expected)
+		}
+	}
+
+	if found := src.Locate("google/api/annotations.proto"); len(found) != 2 {
+		t.Fatalf("Both matches should be located: %v", found)
+	}
+	if _, _, err := src.OpenImport("google/protobuf/empty.proto"); !IsImportNotFound(err) {
+		t.Fatalf("Builtin imports should not be searched in modules: %v", err)
+	}
+	if _, _, err := src.OpenImport("../x.proto"); err == nil {
+		t.Fatalf("Invalid import paths should be rejected")
+	}
+}
@@ -0,0 +1,57 @@
+package fproto
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// ImportSource finds .proto files by their import path, the string used on
+// the "import" statement.
+//
+// If the source doesn't have the file, an *ImportNotFound error must be
+// returned, so other sources can be tried.
+type ImportSource interface {
+	// Opens the file for the import path. The returned location describes
+	// where the file was found, for error messages.
+	OpenImport(path string) (r io.ReadCloser, location string, err error)
+}
+
+// ImportSourceFunc is an adapter to allow using ordinary functions as an ImportSource.
+type ImportSourceFunc func(path string) (io.ReadCloser, string, error)
+
+func (f ImportSourceFunc) OpenImport(path string) (io.ReadCloser, string, error) {
+	return f(path)
+}
+
+// MapImportSource is an import source backed by a map of import path to file contents.
+type MapImportSource map[string]string
+
+func (m MapImportSource) OpenImport(path string) (io.ReadCloser, string, error) {
+	if src, ok := m[path]; ok {
+		return ioutil.NopCloser(strings.NewReader(src)), "map:" + path, nil
+	}
+	return nil, "", &ImportNotFound{Path: path}
+}
+
+// MultiImportSource searches the sources in order, returning the first that has the file.
+type MultiImportSource []ImportSource
+
+func (m MultiImportSource) OpenImport(path string) (io.ReadCloser, string, error) {
+	for _, s := range m {
+		r, location, err := s.OpenImport(path)
+		if err == nil {
+			return r, location, nil
+		}
+		if !IsImportNotFound(err) {
+			return nil, "", err
+		}
+	}
+	return nil, "", &ImportNotFound{Path: path}
+}
+
+// Returns whether the error means the import was not found.
+func IsImportNotFound(err error) bool {
+	_, ok := err.(*ImportNotFound)
+	return ok
+}
@@ -0,0 +1,156 @@
+package fproto
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// IncludePathSource resolves imports like "protoc -I" does: the include
+// directories are searched in order, and the first match wins.
+//
+// Remaps are checked before the include directories, and map an import path
+// prefix to a directory, for generated or vendored layouts.
+type IncludePathSource struct {
+	Dirs   []string
+	Remaps []*ImportRemap
+
+	// Called when an import is found in more than one location.
+	// The warning is also added to Warnings.
+	OnWarning func(w *ImportShadowed)
+
+	// Imports found in more than one location.
+	Warnings []*ImportShadowed
+
+	mu     sync.Mutex
+	warned map[string]bool
+}
+
+// ImportRemap maps import paths starting with Prefix to Dir.
+// Ex: {Prefix: "gen/api", Dir: "build/api"} maps "gen/api/user.proto" to "build/api/user.proto".
+type ImportRemap struct {
+	Prefix string
+	Dir    string
+}
+
+// ImportShadowed is the warning issued when an import path exists in more than
+// one location. Like protoc, the first one is used.
+type ImportShadowed struct {
+	Path     string
+	Used     string
+	Shadowed []string
+}
+
+func (w *ImportShadowed) String() string {
+	return fmt.Sprintf("Import '%s' found at '%s' shadows '%s'", w.Path, w.Used, strings.Join(w.Shadowed, "', '"))
+}
+
+// Creates a new include path source searching the directories in order.
+func NewIncludePathSource(dirs ...string) *IncludePathSource {
+	return &IncludePathSource{
+		Dirs: dirs,
+	}
+}
+
+// Adds a remap rule from an import path prefix to a directory.
+func (s *IncludePathSource) AddRemap(prefix, dir string) {
+	s.Remaps = append(s.Remaps, &ImportRemap{Prefix: strings.TrimSuffix(prefix, "/"), Dir: dir})
+}
+
+func (s *IncludePathSource) OpenImport(importPath string) (io.ReadCloser, string, error) {
+	if !IsValidImportPath(importPath) {
+		return nil, "", &InvalidImportPath{Path: importPath}
+	}
+
+	found := s.Locate(importPath)
+	if len(found) == 0 {
+		return nil, "", &ImportNotFound{Path: importPath}
+	}
+	if len(found) > 1 {
+		s.warnShadowed(&ImportShadowed{Path: importPath, Used: found[0], Shadowed: found[1:]})
+	}
+
+	f, err := os.Open(found[0])
+	if err != nil {
+		return nil, "", err
+	}
+	return f, found[0], nil
+}
+
+// Returns all the filesystem locations where the import path exists, in
+// search order. The first one is the one that is used.
+func (s *IncludePathSource) Locate(importPath string) []string {
+	var ret []string
+
+	for _, remap := range s.sortedRemaps() {
+		if rest, ok := cutImportPrefix(importPath, remap.Prefix); ok {
+			if fn := filepath.Join(remap.Dir, filepath.FromSlash(rest)); isRegularFile(fn) {
+				ret = append(ret, fn)
+			}
+		}
+	}
+
+	for _, dir := range s.Dirs {
+		if fn := filepath.Join(dir, filepath.FromSlash(importPath)); isRegularFile(fn) {
+			ret = append(ret, fn)
+		}
+	}
+
+	return ret
+}
+
+// remaps sorted by longest prefix first, so the most specific wins
+func (s *IncludePathSource) sortedRemaps() []*ImportRemap {
+	ret := append([]*ImportRemap(nil), s.Remaps...)
+	sort.SliceStable(ret, func(i, j int) bool {
+		return len(ret[i].Prefix) > len(ret[j].Prefix)
+	})
+	return ret
+}
+
+func (s *IncludePathSource) warnShadowed(w *ImportShadowed) {
+	s.mu.Lock()
+	if s.warned == nil {
+		s.warned = make(map[string]bool)
+	}
+	if s.warned[w.Path] {
+		s.mu.Unlock()
+		return
+	}
+	s.warned[w.Path] = true
+	s.Warnings = append(s.Warnings, w)
+	s.mu.Unlock()
+
+	if s.OnWarning != nil {
+		s.OnWarning(w)
+	}
+}
+
+// Returns whether the import path is relative, slash-separated and clean, as protoc requires.
+func IsValidImportPath(importPath string) bool {
+	if importPath == "" || strings.Contains(importPath, "\\") || path.IsAbs(importPath) {
+		return false
+	}
+	return path.Clean(importPath) == importPath && !strings.HasPrefix(importPath, "../") && importPath != ".."
+}
+
+// Returns the part of the import path after the directory prefix.
+func cutImportPrefix(importPath, prefix string) (string, bool) {
+	if prefix == "" {
+		return importPath, true
+	}
+	if strings.HasPrefix(importPath, prefix+"/") {
+		return importPath[len(prefix)+1:], true
+	}
+	return "", false
+}
+
+func isRegularFile(fn string) bool {
+	st, err := os.Stat(fn)
+	return err == nil && st.Mode().IsRegular()
+}
@@ -0,0 +1,81 @@
+package fproto
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestIncludePathSource(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"first/common/money.proto":  "first",
+		"second/common/money.proto": "second",
+		"second/common/time.proto":  "second time",
+		"build/user.proto":          "build",
+		"build-api/user.proto":      "build api",
+		"first/gen/api/user.proto":  "include dir",
+	})
+
+	s := NewIncludePathSource(filepath.Join(dir, "first"), filepath.Join(dir, "second"))
+	s.AddRemap("gen", filepath.Join(dir, "build"))
+	s.AddRemap("gen/api/", filepath.Join(dir, "build-api"))
+
+	var warnings []*ImportShadowed
+	s.OnWarning = func(w *ImportShadowed) {
+		warnings = append(warnings, w)
+	}
+
+	read := func(importPath string) string {
+		t.Helper()
+		r, _, err := s.OpenImport(importPath)
+		if err != nil {
+			t.Fatalf("Error opening '%s': %v", importPath, err)
+		}
+		defer r.Close()
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	// the first include directory wins, and the shadowing is warned once
+	if c := read("common/money.proto"); c != "first" {
+		t.Fatalf("First include directory should be used, got '%s'", c)
+	}
+	read("common/money.proto")
+	if c := read("common/time.proto"); c != "second time" {
+		t.Fatalf("Import should be found in the second include directory, got '%s'", c)
+	}
+	if len(warnings) != 1 || len(s.Warnings) != 1 || warnings[0] != s.Warnings[0] {
+		t.Fatalf("Expected one shadowing warning, got %d", len(warnings))
+	}
+	if w := warnings[0]; w.Path != "common/money.proto" || w.Used != filepath.Join(dir, "first", "common", "money.proto") ||
+		len(w.Shadowed) != 1 || w.Shadowed[0] != filepath.Join(dir, "second", "common", "money.proto") {
+		t.Fatalf("Wrong warning: %s", w)
+	}
+
+	// the longest remap prefix wins, and remaps are before the include directories
+	if c := read("gen/api/user.proto"); c != "build api" {
+		t.Fatalf("Longest remap prefix should be used, got '%s'", c)
+	}
+	if c := read("gen/user.proto"); c != "build" {
+		t.Fatalf("Remap should be used, got '%s'", c)
+	}
+	if found := s.Locate("gen/api/user.proto"); len(found) != 2 || found[1] != filepath.Join(dir, "first", "gen", "api", "user.proto") {
+		t.Fatalf("Wrong locations: %v", found)
+	}
+
+	for _, importPath := range []string{"../first/common/money.proto", "/common/money.proto", "common/../common/money.proto", `common\money.proto`, ""} {
+		if _, _, err := s.OpenImport(importPath); err == nil {
+			t.Fatalf("Import path '%s' should be invalid", importPath)
+		} else if _, ok := err.(*InvalidImportPath); !ok {
+			t.Fatalf("Expected an InvalidImportPath error for '%s', got %v", importPath, err)
+		}
+	}
+
+	if _, _, err := s.OpenImport("common/missing.proto"); !IsImportNotFound(err) {
+		t.Fatalf("Expected an ImportNotFound error, got %v", err)
+	}
+}
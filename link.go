@@ -0,0 +1,358 @@
+package fproto
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TypeRefKind is the kind of element property that references a type.
+type TypeRefKind int
+
+const (
+	FieldTypeRef       TypeRefKind = iota + 1 // FieldElement.Type
+	MapValueTypeRef                           // MapFieldElement.Type
+	RPCRequestTypeRef                         // RPCElement.RequestType
+	RPCResponseTypeRef                        // RPCElement.ResponseType
+	ExtendTypeRef                             // extend MessageElement.Name
+)
+
+func (k TypeRefKind) String() string {
+	switch k {
+	case FieldTypeRef:
+		return "field type"
+	case MapValueTypeRef:
+		return "map value type"
+	case RPCRequestTypeRef:
+		return "rpc request type"
+	case RPCResponseTypeRef:
+		return "rpc response type"
+	case ExtendTypeRef:
+		return "extend"
+	}
+	return "unknown"
+}
+
+// TypeRef is a reference by name from an element to a message or enum type.
+type TypeRef struct {
+	// The element containing the reference: *FieldElement, *MapFieldElement,
+	// *RPCElement or an extend *MessageElement
+	Element FProtoElement
+	File    *ProtoFile
+	Kind    TypeRefKind
+	// Name as written in the source
+	Name string
+
+	// Resolved *MessageElement or *EnumElement. Nil if unresolved.
+	Target     FProtoElement
+	TargetFile *ProtoFile
+
+	// When unresolved, the full name and file of a definition that matches
+	// but is not visible from File, as it is not imported directly or publicly.
+	NotVisibleName string
+	NotVisibleFile *ProtoFile
+}
+
+func (r *TypeRef) String() string {
+	return fmt.Sprintf("%s '%s' of %s '%s' in '%s'", r.Kind, r.Name, strings.ToLower(r.Element.ElementTypeName()),
+		FullName(r.Element), r.File.FileName)
+}
+
+// Links are the type references of all files of a FileSet, bound to the
+// elements they reference.
+//
+// Names are resolved using the protobuf scoping rules: the innermost scope
+// is searched first, then outward up to the package root, and names starting
+// with a dot are absolute. Only types defined in the files returned by
+// FileSet.VisibleFiles are visible.
+type Links struct {
+	FileSet *FileSet
+	Refs    []*TypeRef
+
+	symbols  *SymbolTable
+	refs     map[typeRefKey]*TypeRef
+	fileRefs map[*ProtoFile][]*TypeRef
+	visible  map[*ProtoFile]map[*ProtoFile]bool
+}
+
+type typeRefKey struct {
+	element FProtoElement
+	kind    TypeRefKind
+}
+
+// Links all type references of the FileSet files. Links are returned even if
+// some references could not be resolved, in which case the error is an
+// *UnresolvedTypes.
+func Link(fs *FileSet) (*Links, error) {
+	l := &Links{
+		FileSet:  fs,
+		refs:     make(map[typeRefKey]*TypeRef),
+		fileRefs: make(map[*ProtoFile][]*TypeRef),
+	}
+	return l, l.Relink(fs.Files...)
+}
+
+// Links again the type references of the files, after the FileSet changed.
+// Use the files returned by FileSet.Reload. The returned error is the same as Link.
+func (l *Links) Relink(files ...*ProtoFile) error {
+	l.symbols = l.FileSet.Symbols()
+	l.visible = make(map[*ProtoFile]map[*ProtoFile]bool)
+
+	for _, f := range files {
+		for _, r := range l.fileRefs[f] {
+			delete(l.refs, typeRefKey{r.Element, r.Kind})
+		}
+		l.fileRefs[f] = nil
+		l.linkFile(f)
+	}
+
+	// keep the references in file order, dropping files no longer loaded
+	current := make(map[*ProtoFile]bool)
+	l.Refs = nil
+	for _, f := range l.FileSet.Files {
+		current[f] = true
+		l.Refs = append(l.Refs, l.fileRefs[f]...)
+	}
+	for f, refs := range l.fileRefs {
+		if !current[f] {
+			for _, r := range refs {
+				delete(l.refs, typeRefKey{r.Element, r.Kind})
+			}
+			delete(l.fileRefs, f)
+		}
+	}
+
+	if unresolved := l.Unresolved(); len(unresolved) > 0 {
+		return &UnresolvedTypes{Refs: unresolved}
+	}
+	return nil
+}
+
+// Returns the *MessageElement or *EnumElement of the field type, or nil for scalars and unresolved types.
+func (l *Links) FieldType(f *FieldElement) FProtoElement {
+	return l.target(f, FieldTypeRef)
+}
+
+// Returns the *MessageElement or *EnumElement of the map value type, or nil for scalars and unresolved types.
+func (l *Links) MapValueType(f *MapFieldElement) FProtoElement {
+	return l.target(f, MapValueTypeRef)
+}
+
+// Returns the *MessageElement of the rpc request type, or nil if unresolved.
+func (l *Links) RequestType(r *RPCElement) *MessageElement {
+	m, _ := l.target(r, RPCRequestTypeRef).(*MessageElement)
+	return m
+}
+
+// Returns the *MessageElement of the rpc response type, or nil if unresolved.
+func (l *Links) ResponseType(r *RPCElement) *MessageElement {
+	m, _ := l.target(r, RPCResponseTypeRef).(*MessageElement)
+	return m
+}
+
+// Returns the *MessageElement extended by the extend block, or nil if unresolved.
+func (l *Links) ExtendTarget(m *MessageElement) *MessageElement {
+	ret, _ := l.target(m, ExtendTypeRef).(*MessageElement)
+	return ret
+}
+
+// Returns the type reference of the element, or nil if it has none.
+func (l *Links) FindRef(element FProtoElement, kind TypeRefKind) *TypeRef {
+	return l.refs[typeRefKey{element, kind}]
+}
+
+// Returns all the type references that could not be resolved.
+func (l *Links) Unresolved() []*TypeRef {
+	var ret []*TypeRef
+	for _, r := range l.Refs {
+		if r.Target == nil {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+// Returns the extension field named by the custom option, or nil if the option
+// is not a custom one or the extension is not found. The name is resolved from
+// the scope of the element the option is set on, like protoc.
+func (l *Links) OptionExtension(o *OptionElement) *FieldElement {
+	if !o.IsParenthesized || o.ParenthesizedName == "" {
+		return nil
+	}
+	f := EnclosingFile(o)
+	if f == nil {
+		return nil
+	}
+	s := l.resolveSymbol(o.ParenthesizedName, FullName(o.Parent), l.visibleFiles(f), isExtensionSymbol)
+	if s == nil {
+		return nil
+	}
+	return s.Element.(*FieldElement)
+}
+
+func (l *Links) target(element FProtoElement, kind TypeRefKind) FProtoElement {
+	if r := l.FindRef(element, kind); r != nil {
+		return r.Target
+	}
+	return nil
+}
+
+func (l *Links) linkFile(f *ProtoFile) {
+	for _, el := range f.Messages {
+		l.linkMessage(f, el)
+	}
+	for _, el := range f.ExtendMessages {
+		l.linkExtend(f, el)
+	}
+	for _, el := range f.Services {
+		for _, rpc := range el.RPCs {
+			l.addRef(f, rpc, RPCRequestTypeRef, rpc.RequestType, FullName(el))
+			l.addRef(f, rpc, RPCResponseTypeRef, rpc.ResponseType, FullName(el))
+		}
+	}
+}
+
+func (l *Links) linkMessage(f *ProtoFile, m *MessageElement) {
+	l.linkFields(f, m.Fields, FullName(m))
+	for _, el := range m.Messages {
+		l.linkMessage(f, el)
+	}
+	for _, el := range m.ExtendMessages {
+		l.linkExtend(f, el)
+	}
+}
+
+func (l *Links) linkExtend(f *ProtoFile, m *MessageElement) {
+	scope := scopeName(m)
+	l.addRef(f, m, ExtendTypeRef, m.Name, scope)
+	l.linkFields(f, m.Fields, scope)
+}
+
+func (l *Links) linkFields(f *ProtoFile, fields []FieldElementTag, scope string) {
+	for _, fld := range fields {
+		switch xfld := fld.(type) {
+		case *FieldElement:
+			l.addRef(f, xfld, FieldTypeRef, xfld.Type, scope)
+		case *MapFieldElement:
+			l.addRef(f, xfld, MapValueTypeRef, xfld.Type, scope)
+		case *OneOfFieldElement:
+			l.linkFields(f, xfld.Fields, scope)
+		}
+	}
+}
+
+func (l *Links) addRef(f *ProtoFile, element FProtoElement, kind TypeRefKind, name, scope string) {
+	if _, isscalar := scalarLookupMap[name]; isscalar {
+		return
+	}
+
+	r := &TypeRef{
+		Element: element,
+		File:    f,
+		Kind:    kind,
+		Name:    name,
+	}
+	if s := l.resolve(name, scope, l.visibleFiles(f)); s != nil {
+		r.Target = s.Element
+		r.TargetFile = s.File
+	} else if s := l.resolve(name, scope, nil); s != nil {
+		r.NotVisibleName = s.Name
+		r.NotVisibleFile = s.File
+	}
+
+	l.fileRefs[f] = append(l.fileRefs[f], r)
+	l.refs[typeRefKey{element, kind}] = r
+}
+
+// Resolves the type name starting at the scope, like protoc does. Only symbols
+// of the visible files are considered, or all if visible is nil.
+func (l *Links) resolve(name, scope string, visible map[*ProtoFile]bool) *Symbol {
+	return l.resolveSymbol(name, scope, visible, isTypeSymbol)
+}
+
+// Resolves the name like resolve, accepting only the symbols matching accept.
+func (l *Links) resolveSymbol(name, scope string, visible map[*ProtoFile]bool, accept func(*Symbol) bool) *Symbol {
+	if strings.HasPrefix(name, ".") {
+		return l.findAccepted(name[1:], visible, accept)
+	}
+
+	first, _ := NameSplit(name)
+	for {
+		candidate := joinName(scope, first)
+		if s := l.find(candidate, visible); s != nil {
+			if first == name {
+				if accept(s) {
+					return s
+				}
+				// not accepted, keep searching on outer scopes
+			} else if isAggregateSymbol(s) {
+				// the rest of the name must be found inside it
+				return l.findAccepted(joinName(scope, name), visible, accept)
+			}
+		}
+		if scope == "" {
+			return nil
+		}
+		if i := strings.LastIndex(scope, "."); i >= 0 {
+			scope = scope[:i]
+		} else {
+			scope = ""
+		}
+	}
+}
+
+func (l *Links) findAccepted(name string, visible map[*ProtoFile]bool, accept func(*Symbol) bool) *Symbol {
+	if s := l.find(name, visible); s != nil && accept(s) {
+		return s
+	}
+	return nil
+}
+
+// Finds the symbol among the visible ones. Packages are always visible.
+func (l *Links) find(name string, visible map[*ProtoFile]bool) *Symbol {
+	for _, s := range l.symbols.LookupAll(name) {
+		if visible == nil || s.IsPackage() || visible[s.File] {
+			return s
+		}
+	}
+	return nil
+}
+
+func (l *Links) visibleFiles(f *ProtoFile) map[*ProtoFile]bool {
+	if v, ok := l.visible[f]; ok {
+		return v
+	}
+
+	v := make(map[*ProtoFile]bool)
+	for _, vf := range l.FileSet.VisibleFiles(f) {
+		v[vf] = true
+	}
+	l.visible[f] = v
+	return v
+}
+
+func isTypeSymbol(s *Symbol) bool {
+	switch el := s.Element.(type) {
+	case *MessageElement:
+		return !el.IsExtend
+	case *EnumElement:
+		return true
+	}
+	return false
+}
+
+// Fields declared in extend blocks
+func isExtensionSymbol(s *Symbol) bool {
+	if fld, ok := s.Element.(*FieldElement); ok {
+		m, ok := fld.Parent.(*MessageElement)
+		return ok && m.IsExtend
+	}
+	return false
+}
+
+func isAggregateSymbol(s *Symbol) bool {
+	switch s.Element.(type) {
+	case *ProtoFile, *MessageElement, *EnumElement, *ServiceElement:
+		return true
+	}
+	return false
+}
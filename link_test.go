@@ -0,0 +1,136 @@
+package fproto
+
+import (
+	"testing"
+)
+
+var (
+	testlinkset = MapImportSource{
+		"common/money.proto": `
+syntax = "proto3";
+package common;
+message Money {
+	string currency = 1;
+	int64 units = 2;
+}
+`,
+		"common/all.proto": `
+syntax = "proto3";
+package common;
+import public "common/money.proto";
+`,
+		"internal/secret.proto": `
+syntax = "proto3";
+package internal;
+message Secret {}
+`,
+		"common/wrap.proto": `
+syntax = "proto3";
+package common;
+import "internal/secret.proto";
+`,
+		"user/user.proto": `
+syntax = "proto3";
+package p_user;
+import "common/all.proto";
+import "common/wrap.proto";
+import "google/protobuf/descriptor.proto";
+
+extend google.protobuf.FieldOptions {
+	bool sensitive = 50000;
+}
+
+message User {
+	message Address {
+		string address = 1;
+		Kind kind = 2;
+	}
+	enum Kind {
+		HOME = 0;
+		WORK = 1;
+	}
+
+	int32 id = 1;
+	Address address = 2;
+	.p_user.User.Address other_address = 3;
+	common.Money balance = 4;
+	map<string, Address> addresses = 5;
+	oneof contact {
+		Kind kind = 6;
+		internal.Secret secret = 7;
+	}
+}
+
+service UserService {
+	rpc GetUser(User) returns (User.Address);
+}
+`,
+	}
+)
+
+func TestLink(t *testing.T) {
+	fs := NewFileSet(testlinkset)
+	pfile, err := fs.LoadFile("user/user.proto")
+	if err != nil {
+		t.Fatalf("Error loading proto file: %v", err)
+	}
+
+	links, err := Link(fs)
+	if err == nil {
+		t.Fatalf("Expected unresolved types error")
+	}
+
+	unresolved := links.Unresolved()
+	if len(unresolved) != 1 || unresolved[0].Name != "internal.Secret" {
+		t.Fatalf("Only internal.Secret should be unresolved as it is not visible, got %v", err)
+	}
+
+	if unresolved[0].NotVisibleFile == nil || unresolved[0].NotVisibleFile.FileName != "internal/secret.proto" {
+		t.Fatalf("internal.Secret should be reported as defined in a file not imported")
+	}
+
+	if fs.IsVisible(pfile, fs.FindFile("internal/secret.proto")) {
+		t.Fatalf("internal/secret.proto is not publicly imported and should not be visible")
+	}
+
+	if !fs.IsVisible(pfile, fs.FindFile("common/money.proto")) {
+		t.Fatalf("common/money.proto is publicly imported and should be visible")
+	}
+
+	user := pfile.FindName("User")[0].(*MessageElement)
+	address := pfile.FindName("User.Address")[0].(*MessageElement)
+	kind := pfile.FindName("User.Kind")[0].(*EnumElement)
+
+	for _, fname := range []string{"address", "other_address"} {
+		if tp := links.FieldType(user.FindField(fname).(*FieldElement)); tp != address {
+			t.Fatalf("Field '%s' should be linked to User.Address, got %v", fname, tp)
+		}
+	}
+
+	if tp := links.FieldType(address.FindField("kind").(*FieldElement)); tp != kind {
+		t.Fatalf("Field 'kind' should be linked to User.Kind from the nested scope, got %v", tp)
+	}
+
+	money, ok := links.FieldType(user.FindField("balance").(*FieldElement)).(*MessageElement)
+	if !ok || money.Name != "Money" {
+		t.Fatalf("Field 'balance' should be linked to common.Money through the public import")
+	}
+
+	if tp := links.MapValueType(user.FindField("addresses").(*MapFieldElement)); tp != address {
+		t.Fatalf("Map field 'addresses' value should be linked to User.Address, got %v", tp)
+	}
+
+	if tp := links.FieldType(user.FindField("contact").(*OneOfFieldElement).Fields[0].(*FieldElement)); tp != kind {
+		t.Fatalf("Oneof field 'kind' should be linked to User.Kind, got %v", tp)
+	}
+
+	rpc := pfile.Services[0].RPCs[0]
+	if links.RequestType(rpc) != user || links.ResponseType(rpc) != address {
+		t.Fatalf("RPC types not linked")
+	}
+
+	extendee := links.ExtendTarget(pfile.ExtendMessages[0])
+	if extendee == nil || extendee.Name != "FieldOptions" {
+		t.Fatalf("Extend should be linked to google.protobuf.FieldOptions")
+	}
+}
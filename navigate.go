@@ -0,0 +1,131 @@
+package fproto
+
+// Returns the parents of the element, from the nearest to the root.
+func Ancestors(element FProtoElement) []FProtoElement {
+	var ret []FProtoElement
+	for cur := element.ParentElement(); cur != nil; cur = cur.ParentElement() {
+		ret = append(ret, cur)
+	}
+	return ret
+}
+
+// Returns the number of parents of the element. The ProtoFile has depth 0.
+func Depth(element FProtoElement) int {
+	return len(Ancestors(element))
+}
+
+// Returns the nearest message containing the element, not counting extend
+// blocks, or nil if it is not inside a message.
+func EnclosingMessage(element FProtoElement) *MessageElement {
+	for _, a := range Ancestors(element) {
+		if m, ok := a.(*MessageElement); ok && !m.IsExtend {
+			return m
+		}
+	}
+	return nil
+}
+
+// Returns the file of the element, or nil if not part of a file. Returns the
+// element itself if it is a ProtoFile.
+func EnclosingFile(element FProtoElement) *ProtoFile {
+	if f, ok := element.(*ProtoFile); ok {
+		return f
+	}
+	return Enclosing[*ProtoFile](element)
+}
+
+// Returns the nearest parent of the element with type T, or the zero value if
+// there is none.
+//
+// Ex: Enclosing[*ServiceElement](option)
+func Enclosing[T FProtoElement](element FProtoElement) T {
+	for _, a := range Ancestors(element) {
+		if t, ok := a.(T); ok {
+			return t
+		}
+	}
+	var zero T
+	return zero
+}
+
+// Returns the direct children of the element, in Walk order.
+func Children(element FProtoElement) []FProtoElement {
+	var ret []FProtoElement
+	eachChild(element, func(child FProtoElement) bool {
+		if child != nil {
+			ret = append(ret, child)
+		}
+		return true
+	})
+	return ret
+}
+
+// Returns the direct children of the element with type T, in Walk order.
+//
+// Ex: ChildrenOf[*OneOfFieldElement](message)
+func ChildrenOf[T FProtoElement](element FProtoElement) []T {
+	var ret []T
+	for _, child := range Children(element) {
+		if t, ok := child.(T); ok {
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+// Returns the elements of type T in the element tree, including the element
+// itself, in Walk order.
+//
+// Ex: DescendantsOf[*FieldElement](file)
+func DescendantsOf[T FProtoElement](element FProtoElement) []T {
+	var ret []T
+	Inspect(element, func(el FProtoElement) bool {
+		if t, ok := el.(T); ok {
+			ret = append(ret, t)
+		}
+		return true
+	})
+	return ret
+}
+
+// Returns the index of the element in its parent list, like the message
+// fields or the enum constants. Returns -1 if the element has no parent or
+// is not in its parent lists.
+func Index(element FProtoElement) int {
+	_, index := parentList(element)
+	return index
+}
+
+// Returns the element after this one in its parent list, or nil if it is the last one.
+func NextSibling(element FProtoElement) FProtoElement {
+	return siblingAt(element, 1)
+}
+
+// Returns the element before this one in its parent list, or nil if it is the first one.
+func PrevSibling(element FProtoElement) FProtoElement {
+	return siblingAt(element, -1)
+}
+
+func siblingAt(element FProtoElement, offset int) FProtoElement {
+	list, index := parentList(element)
+	if index < 0 || index+offset < 0 || index+offset >= list.len(element.ParentElement()) {
+		return nil
+	}
+	return list.at(element.ParentElement(), index+offset)
+}
+
+// Returns the parent list containing the element, and its index.
+func parentList(element FProtoElement) (childList, int) {
+	parent := element.ParentElement()
+	if parent == nil {
+		return childList{}, -1
+	}
+	for _, l := range elementChildLists(parent) {
+		for i, n := 0, l.len(parent); i < n; i++ {
+			if l.at(parent, i) == element {
+				return l, i
+			}
+		}
+	}
+	return childList{}, -1
+}
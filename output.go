@@ -0,0 +1,449 @@
+package fproto
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Name of the manifest file written in the output directory
+const OutputManifestName = ".fproto-gen.json"
+
+// OutputManager collects the files of a generator, and writes them to the
+// output directory. Generated files not generated anymore are removed, using
+// the manifest of the previous run, so files not written by the generator
+// are never touched.
+type OutputManager struct {
+	Dir       string
+	Generator string
+
+	// Don't change any file, Write only returns the changes
+	DryRun bool
+
+	files map[string]*OutputFile
+	names []string
+}
+
+// OutputFile is a generated file, with the proto files it was generated from.
+type OutputFile struct {
+	Name    string
+	Content []byte
+	Sources []string
+}
+
+// OutputManifest lists the generated files. It is stored as JSON in the output directory.
+type OutputManifest struct {
+	Generator string                `json:"generator"`
+	Files     []*OutputManifestFile `json:"files"`
+}
+
+// OutputManifestFile is a generated file in the manifest.
+type OutputManifestFile struct {
+	Name    string   `json:"name"`
+	Hash    string   `json:"hash"`
+	Sources []string `json:"sources,omitempty"`
+}
+
+type OutputChangeKind int
+
+const (
+	OutputAdded OutputChangeKind = iota
+	OutputModified
+	OutputRemoved
+)
+
+func (k OutputChangeKind) String() string {
+	switch k {
+	case OutputAdded:
+		return "added"
+	case OutputModified:
+		return "modified"
+	case OutputRemoved:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// OutputChange is a file that is different in the output directory.
+type OutputChange struct {
+	Kind OutputChangeKind
+	Name string
+	Old  []byte // nil if added
+	New  []byte // nil if removed
+}
+
+// Creates an output manager writing to dir.
+func NewOutputManager(dir, generator string) *OutputManager {
+	return &OutputManager{
+		Dir:       dir,
+		Generator: generator,
+		files:     make(map[string]*OutputFile),
+	}
+}
+
+// Adds a generated file. The name is a slash separated path relative to the
+// output directory.
+func (m *OutputManager) Add(name string, content []byte, sources ...string) error {
+	if !IsValidImportPath(name) || name == OutputManifestName {
+		return fmt.Errorf("Invalid output file name '%s'", name)
+	}
+	if m.files == nil {
+		m.files = make(map[string]*OutputFile)
+	}
+	if _, ok := m.files[name]; ok {
+		return fmt.Errorf("Output file '%s' generated more than once", name)
+	}
+	m.files[name] = &OutputFile{
+		Name:    name,
+		Content: content,
+		Sources: sources,
+	}
+	m.names = append(m.names, name)
+	return nil
+}
+
+// Adds all the files generated by plugins.
+func (m *OutputManager) AddPluginOutput(out *PluginOutput, sources ...string) error {
+	for _, name := range out.Names {
+		if err := m.Add(name, []byte(out.Files[name]), sources...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the added files, in order.
+func (m *OutputManager) Files() []*OutputFile {
+	var ret []*OutputFile
+	for _, name := range m.names {
+		ret = append(ret, m.files[name])
+	}
+	return ret
+}
+
+// Returns the "DO NOT EDIT" header for a file generated from the sources,
+// with each line starting with the comment prefix, like "//" or "#".
+//
+// The first line follows the Go convention for generated files.
+func (m *OutputManager) Header(commentPrefix string, sources ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s Code generated by %s. DO NOT EDIT.\n", commentPrefix, m.Generator)
+	for _, src := range sources {
+		fmt.Fprintf(&b, "%s source: %s\n", commentPrefix, src)
+	}
+	return b.String()
+}
+
+// Returns the manifest of the added files.
+func (m *OutputManager) Manifest() *OutputManifest {
+	ret := &OutputManifest{
+		Generator: m.Generator,
+	}
+	for _, f := range m.Files() {
+		ret.Files = append(ret.Files, &OutputManifestFile{
+			Name:    f.Name,
+			Hash:    ContentHash(f.Content),
+			Sources: f.Sources,
+		})
+	}
+	sort.Slice(ret.Files, func(i, j int) bool {
+		return ret.Files[i].Name < ret.Files[j].Name
+	})
+	return ret
+}
+
+// Reads the manifest of the output directory, returning an empty one if not found.
+// File names must be valid output names, so no file outside the directory is
+// ever removed.
+func (m *OutputManager) ReadManifest() (*OutputManifest, error) {
+	ret := &OutputManifest{}
+	err := readJSONFile(filepath.Join(m.Dir, OutputManifestName), ret)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, f := range ret.Files {
+		if !IsValidImportPath(f.Name) || f.Name == OutputManifestName {
+			return nil, fmt.Errorf("Invalid output file name '%s' in the manifest", f.Name)
+		}
+	}
+	return ret, nil
+}
+
+// Compares the added files with the output directory. Files in the previous
+// manifest that were not added are removed. If one of them doesn't have the
+// hash of the manifest anymore, it was changed by the user, and an
+// *OutputFileModified error is returned.
+func (m *OutputManager) Changes() ([]*OutputChange, error) {
+	prev, err := m.ReadManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*OutputChange
+	for _, f := range m.Files() {
+		old, err := ioutil.ReadFile(m.fileName(f.Name))
+		switch {
+		case os.IsNotExist(err):
+			ret = append(ret, &OutputChange{Kind: OutputAdded, Name: f.Name, New: f.Content})
+		case err != nil:
+			return nil, err
+		case !bytes.Equal(old, f.Content):
+			ret = append(ret, &OutputChange{Kind: OutputModified, Name: f.Name, Old: old, New: f.Content})
+		}
+	}
+
+	for _, pf := range prev.Files {
+		if _, ok := m.files[pf.Name]; ok {
+			continue
+		}
+		old, err := ioutil.ReadFile(m.fileName(pf.Name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if ContentHash(old) != pf.Hash {
+			return nil, &OutputFileModified{Name: pf.Name}
+		}
+		ret = append(ret, &OutputChange{Kind: OutputRemoved, Name: pf.Name, Old: old})
+	}
+
+	return ret, nil
+}
+
+// Writes the changed files atomically, removes the stale ones and writes
+// the manifest. Returns the changes, which are not applied if DryRun is set.
+func (m *OutputManager) Write() ([]*OutputChange, error) {
+	changes, err := m.Changes()
+	if err != nil || m.DryRun {
+		return changes, err
+	}
+
+	for _, c := range changes {
+		fn := m.fileName(c.Name)
+		if c.Kind == OutputRemoved {
+			if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			removeEmptyDirs(m.Dir, filepath.Dir(fn))
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(fn, c.New); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.MarshalIndent(m.Manifest(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(filepath.Join(m.Dir, OutputManifestName), append(data, '\n')); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// Returns an *OutputOutOfDate error if the output directory is not the same
+// as the added files, for checking that committed generated code is up to date.
+func (m *OutputManager) Check() error {
+	changes, err := m.Changes()
+	if err != nil {
+		return err
+	}
+	if len(changes) > 0 {
+		return &OutputOutOfDate{Changes: changes}
+	}
+	return nil
+}
+
+func (m *OutputManager) fileName(name string) string {
+	return filepath.Join(m.Dir, filepath.FromSlash(name))
+}
+
+// Writes the changes as a line diff, in the unified diff format without context lines.
+func WriteOutputDiff(w io.Writer, changes []*OutputChange) error {
+	for _, c := range changes {
+		oldName, newName := "a/"+c.Name, "b/"+c.Name
+		if c.Kind == OutputAdded {
+			oldName = "/dev/null"
+		} else if c.Kind == OutputRemoved {
+			newName = "/dev/null"
+		}
+		if _, err := fmt.Fprintf(w, "--- %s\n+++ %s\n", oldName, newName); err != nil {
+			return err
+		}
+		for _, h := range diffLines(splitLines(c.Old), splitLines(c.New)) {
+			if _, err := io.WriteString(w, h); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the diff hunks, using the longest common subsequence of lines.
+func diffLines(a, b []string) []string {
+	akeep, bkeep := make([]bool, len(a)), make([]bool, len(b))
+	markCommonLines(a, b, akeep, bkeep)
+
+	var ret []string
+	var del, add []string
+	delStart, addStart := 0, 0
+	flush := func() {
+		if len(del) > 0 || len(add) > 0 {
+			ret = append(ret, fmt.Sprintf("@@ -%s +%s @@\n", hunkRange(delStart, len(del)), hunkRange(addStart, len(add))))
+			for _, l := range del {
+				ret = append(ret, "-"+l)
+			}
+			for _, l := range add {
+				ret = append(ret, "+"+l)
+			}
+		}
+		del, add = nil, nil
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && akeep[i] && bkeep[j] {
+			flush()
+			i++
+			j++
+			continue
+		}
+		if len(del) == 0 && len(add) == 0 {
+			delStart, addStart = i, j
+		}
+		if i < len(a) && !akeep[i] {
+			del = append(del, a[i])
+			i++
+		} else {
+			add = append(add, b[j])
+			j++
+		}
+	}
+	flush()
+	return ret
+}
+
+// Marks the lines of a longest common subsequence of a and b, using the linear
+// space variant of the Myers diff algorithm: the middle snake of the shortest
+// edit path splits the problem in two smaller ones.
+func markCommonLines(a, b []string, akeep, bkeep []bool) {
+	for {
+		// common prefix and suffix
+		for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+			akeep[0], bkeep[0] = true, true
+			a, b, akeep, bkeep = a[1:], b[1:], akeep[1:], bkeep[1:]
+		}
+		for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+			akeep[len(a)-1], bkeep[len(b)-1] = true, true
+			a, b, akeep, bkeep = a[:len(a)-1], b[:len(b)-1], akeep[:len(a)-1], bkeep[:len(b)-1]
+		}
+		if len(a) == 0 || len(b) == 0 {
+			return
+		}
+
+		x, y, u, v := middleSnake(a, b)
+		for i := x; i < u; i++ {
+			akeep[i], bkeep[y+i-x] = true, true
+		}
+		markCommonLines(a[:x], b[:y], akeep[:x], bkeep[:y])
+		a, b, akeep, bkeep = a[u:], b[v:], akeep[u:], bkeep[v:]
+	}
+}
+
+// Returns the middle snake of the shortest edit path from a to b, from (x, y)
+// to (u, v). a and b must not be empty and must differ in their first and last lines.
+func middleSnake(a, b []string) (x, y, u, v int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	off := n + m + 1
+	// furthest x of each diagonal k = x-y, forward from (0, 0) and backward
+	// from (n, m), the backward one in the coordinates of the reversed lines
+	fwd := make([]int, 2*off+1)
+	bwd := make([]int, 2*off+1)
+
+	for d := 0; d <= (n+m+1)/2; d++ {
+		for k := -d; k <= d; k += 2 {
+			x := fwd[off+k+1]
+			if k != -d && (k == d || fwd[off+k-1] >= fwd[off+k+1]) {
+				x = fwd[off+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			fwd[off+k] = x
+			if rk := delta - k; odd && rk >= -(d-1) && rk <= d-1 && x+bwd[off+rk] >= n {
+				return x0, y0, x, y
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			x := bwd[off+k+1]
+			if k != -d && (k == d || bwd[off+k-1] >= bwd[off+k+1]) {
+				x = bwd[off+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+			bwd[off+k] = x
+			if rk := delta - k; !odd && rk >= -d && rk <= d && x+fwd[off+rk] >= n {
+				return n - x, m - y, n - x0, m - y0
+			}
+		}
+	}
+	// not reached, the paths always overlap
+	return 0, 0, 0, 0
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// Splits the lines keeping the line endings. A missing final line ending is marked.
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	ret := strings.SplitAfter(string(data), "\n")
+	if ret[len(ret)-1] == "" {
+		ret = ret[:len(ret)-1]
+	} else {
+		ret[len(ret)-1] += "\n\\ No newline at end of file\n"
+	}
+	return ret
+}
+
+// Removes dir and its parents up to root while they are empty.
+func removeEmptyDirs(root, dir string) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
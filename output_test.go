@@ -0,0 +1,139 @@
+package fproto
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutputManager(t *testing.T) {
+	dir := t.TempDir()
+
+	m := NewOutputManager(dir, "protoc-gen-test")
+	header := m.Header("//", "shop/product.proto")
+	m.Add("shop/product.txt", []byte(header+"Product\n"), "shop/product.proto")
+	m.Add("shop/old.txt", []byte("Old\n"))
+	if _, err := m.Write(); err != nil {
+		t.Fatalf("Error writing output: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "manual.txt"), []byte("manual"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(header, "// Code generated by protoc-gen-test. DO NOT EDIT.\n") {
+		t.Fatalf("Wrong header: %s", header)
+	}
+
+	// second run, one file changed and one not generated anymore
+	m = NewOutputManager(dir, "protoc-gen-test")
+	m.Add("shop/product.txt", []byte(header+"Product\nPrice\n"), "shop/product.proto")
+
+	if err := m.Check(); err == nil {
+		t.Fatalf("Check should fail when the output is out of date")
+	}
+
+	m.DryRun = true
+	changes, err := m.Write()
+	if err != nil {
+		t.Fatalf("Error in dry run: %v", err)
+	}
+	if len(changes) != 2 || changes[0].Kind != OutputModified || changes[1].Kind != OutputRemoved || changes[1].Name != "shop/old.txt" {
+		t.Fatalf("Wrong changes: %v", changes)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "shop", "old.txt")); err != nil {
+		t.Fatalf("Dry run should not remove files")
+	}
+
+	var diff bytes.Buffer
+	if err := WriteOutputDiff(&diff, changes[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if diff.String() != "--- a/shop/product.txt\n+++ b/shop/product.txt\n@@ -3,0 +4 @@\n+Price\n" {
+		t.Fatalf("Wrong diff: %q", diff.String())
+	}
+
+	m.DryRun = false
+	if _, err := m.Write(); err != nil {
+		t.Fatalf("Error writing output: %v", err)
+	}
+	if err := m.Check(); err != nil {
+		t.Fatalf("Output should be up to date: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "shop", "old.txt")); !os.IsNotExist(err) {
+		t.Fatalf("Stale file should be removed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "manual.txt")); err != nil {
+		t.Fatalf("Files not generated should not be removed")
+	}
+}
+
+func TestOutputManifestOutsideDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "gen")
+	outside := filepath.Join(root, "x.txt")
+	writeTestFiles(t, root, map[string]string{
+		"x.txt":                     "keep",
+		"gen/" + OutputManifestName: `{"generator": "protoc-gen-test", "files": [{"name": "../x.txt", "hash": ""}]}`,
+	})
+
+	m := NewOutputManager(dir, "protoc-gen-test")
+	if _, err := m.Write(); err == nil {
+		t.Fatalf("Invalid manifest file names should be an error")
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Fatalf("Files outside the output directory should not be removed")
+	}
+}
+
+func TestOutputModifiedFile(t *testing.T) {
+	dir := t.TempDir()
+
+	m := NewOutputManager(dir, "protoc-gen-test")
+	m.Add("shop/old.txt", []byte("Old\n"))
+	if _, err := m.Write(); err != nil {
+		t.Fatalf("Error writing output: %v", err)
+	}
+	fn := filepath.Join(dir, "shop", "old.txt")
+	if err := ioutil.WriteFile(fn, []byte("Old\nEdited\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stale file was edited, so it is not removed
+	m = NewOutputManager(dir, "protoc-gen-test")
+	_, err := m.Write()
+	if e, ok := err.(*OutputFileModified); !ok || e.Name != "shop/old.txt" {
+		t.Fatalf("Expected an OutputFileModified error, got %v", err)
+	}
+	if _, err := os.Stat(fn); err != nil {
+		t.Fatalf("Modified file should not be removed")
+	}
+}
+
+func TestOutputDiff(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 50000; i++ {
+		line := fmt.Sprintf("line %d\n", i)
+		if i != 100 {
+			a = append(a, line)
+		}
+		if i != 40000 {
+			b = append(b, line)
+		}
+	}
+	b[20000] = "changed\n"
+
+	// large files must not need quadratic memory
+	hunks := diffLines(a, b)
+	expected := []string{
+		"@@ -100,0 +101 @@\n", "+line 100\n",
+		"@@ -20000 +20001 @@\n", "-line 20000\n", "+changed\n",
+		"@@ -40000 +40000,0 @@\n", "-line 40000\n",
+	}
+	if strings.Join(hunks, "") != strings.Join(expected, "") {
+		t.Fatalf("Wrong diff: %q", hunks)
+	}
+}
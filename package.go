@@ -0,0 +1,190 @@
+package fproto
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Options that all files of a package should agree on.
+var PackageConsistentOptions = []string{"go_package", "java_package"}
+
+// Package is the view of all the files of a FileSet that declare the same package.
+type Package struct {
+	Name  string
+	Files []*ProtoFile
+
+	// Top-level elements of all files, in file order
+	Messages []*PackageElement
+	Enums    []*PackageElement
+	Services []*PackageElement
+	// Extension fields, of top-level or nested extend blocks
+	Extensions []*PackageElement
+
+	// Duplicate definitions and files disagreeing on options
+	Problems []*PackageProblem
+}
+
+// PackageElement is an element declared in a package, with the file it was declared in.
+type PackageElement struct {
+	Element FProtoElement
+	File    *ProtoFile
+}
+
+func (e *PackageElement) FullName() string {
+	return FullName(e.Element)
+}
+
+type PackageProblemKind int
+
+const (
+	DuplicateDefinitionProblem PackageProblemKind = iota + 1
+	ConflictingOptionProblem
+)
+
+// PackageProblem is an inconsistency between the files of a package.
+type PackageProblem struct {
+	Kind PackageProblemKind
+	// Duplicated full name, or option name
+	Name  string
+	Files []*ProtoFile
+	// Conflicting option values, in the same order as Files. Empty if the file doesn't set the option.
+	Values []string
+}
+
+func (p *PackageProblem) String() string {
+	var files []string
+	for _, f := range p.Files {
+		files = append(files, f.FileName)
+	}
+	switch p.Kind {
+	case DuplicateDefinitionProblem:
+		return fmt.Sprintf("'%s' is defined more than once, in '%s'", p.Name, strings.Join(files, "', '"))
+	case ConflictingOptionProblem:
+		var values []string
+		for i, f := range files {
+			values = append(values, fmt.Sprintf("'%s' in '%s'", p.Values[i], f))
+		}
+		return fmt.Sprintf("Files disagree on option '%s': %s", p.Name, strings.Join(values, ", "))
+	}
+	return p.Name
+}
+
+// Returns all the packages of the loaded files, sorted by name. Files without
+// a package statement are grouped in the package with the empty name.
+func (s *FileSet) Packages() []*Package {
+	var names []string
+	seen := make(map[string]bool)
+	for _, f := range s.Files {
+		if !seen[f.PackageName] {
+			seen[f.PackageName] = true
+			names = append(names, f.PackageName)
+		}
+	}
+	sort.Strings(names)
+
+	var ret []*Package
+	for _, name := range names {
+		ret = append(ret, s.Package(name))
+	}
+	return ret
+}
+
+// Returns the view of a package, or nil if no loaded file declares it.
+func (s *FileSet) Package(name string) *Package {
+	p := &Package{
+		Name: name,
+	}
+
+	for _, f := range s.Files {
+		if f.PackageName != name {
+			continue
+		}
+		p.Files = append(p.Files, f)
+
+		for _, el := range f.Messages {
+			p.Messages = append(p.Messages, &PackageElement{el, f})
+		}
+		for _, el := range f.Enums {
+			p.Enums = append(p.Enums, &PackageElement{el, f})
+		}
+		for _, el := range f.Services {
+			p.Services = append(p.Services, &PackageElement{el, f})
+		}
+		for _, el := range f.CollectExtendMessages() {
+			for _, fld := range el.(*MessageElement).Fields {
+				p.Extensions = append(p.Extensions, &PackageElement{fld, f})
+			}
+		}
+	}
+
+	if len(p.Files) == 0 {
+		return nil
+	}
+
+	p.checkDuplicates(s.Symbols())
+	p.checkOptions()
+
+	return p
+}
+
+// Finds a top-level message, enum or service of the package by name.
+func (p *Package) FindName(name string) *PackageElement {
+	for _, list := range [][]*PackageElement{p.Messages, p.Enums, p.Services} {
+		for _, el := range list {
+			if el.Element.ElementName() == name {
+				return el
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Package) checkDuplicates(symbols *SymbolTable) {
+	var lastName string
+	for _, sym := range symbols.Package(p.Name) {
+		if sym.Name == lastName {
+			continue
+		}
+		lastName = sym.Name
+
+		var defs []*Symbol
+		for _, s := range symbols.LookupAll(sym.Name) {
+			if !s.IsPackage() {
+				defs = append(defs, s)
+			}
+		}
+		if len(defs) > 1 {
+			problem := &PackageProblem{
+				Kind: DuplicateDefinitionProblem,
+				Name: sym.Name,
+			}
+			for _, s := range defs {
+				problem.Files = append(problem.Files, s.File)
+			}
+			p.Problems = append(p.Problems, problem)
+		}
+	}
+}
+
+func (p *Package) checkOptions() {
+	for _, oname := range PackageConsistentOptions {
+		problem := &PackageProblem{
+			Kind: ConflictingOptionProblem,
+			Name: oname,
+		}
+		values := make(map[string]bool)
+		for _, f := range p.Files {
+			value := ""
+			if o := f.FindOption(oname); o != nil && o.Value != nil {
+				value = o.Value.Source
+			}
+			values[value] = true
+			problem.Files = append(problem.Files, f)
+			problem.Values = append(problem.Values, value)
+		}
+		if len(values) > 1 {
+			p.Problems = append(p.Problems, problem)
+		}
+	}
+}
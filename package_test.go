@@ -0,0 +1,126 @@
+package fproto
+
+import (
+	"strings"
+	"testing"
+)
+
+var testpackageset = MapImportSource{
+	"shop/product.proto": `
+syntax = "proto3";
+package shop;
+option go_package = "example.com/shop";
+import "google/protobuf/descriptor.proto";
+
+message Product {
+	string id = 1;
+}
+
+enum Status {
+	ACTIVE = 0;
+}
+
+service ProductService {
+	rpc Get(Product) returns (Product);
+}
+
+extend google.protobuf.FieldOptions {
+	bool sensitive = 50000;
+}
+`,
+	"shop/order.proto": `
+syntax = "proto3";
+package shop;
+option go_package = "example.com/shop";
+import "shop/product.proto";
+
+message Order {
+	Product product = 1;
+
+	extend google.protobuf.MessageOptions {
+		string table = 50001;
+	}
+}
+`,
+	"shop/legacy.proto": `
+syntax = "proto3";
+package shop;
+option go_package = "example.com/legacy";
+
+message Product {
+}
+`,
+	"other/other.proto": `
+syntax = "proto3";
+package other;
+message Product {
+}
+`,
+}
+
+func TestPackage(t *testing.T) {
+	fs := NewFileSet(testpackageset)
+	if err := fs.Load("shop/order.proto", "shop/legacy.proto", "other/other.proto"); err != nil {
+		t.Fatalf("Error loading proto files: %v", err)
+	}
+
+	var names []string
+	for _, p := range fs.Packages() {
+		names = append(names, p.Name)
+	}
+	if strings.Join(names, ",") != "google.protobuf,other,shop" {
+		t.Fatalf("Wrong packages: %v", names)
+	}
+
+	p := fs.Package("shop")
+	if len(p.Files) != 3 {
+		t.Fatalf("Expected 3 files, got %d", len(p.Files))
+	}
+
+	// elements of all files, in file order, with the file they are declared in
+	var elements []string
+	for _, list := range [][]*PackageElement{p.Messages, p.Enums, p.Services, p.Extensions} {
+		for _, el := range list {
+			elements = append(elements, el.FullName()+"@"+el.File.FileName)
+		}
+	}
+	expected := []string{
+		"shop.Product@shop/product.proto",
+		"shop.Order@shop/order.proto",
+		"shop.Product@shop/legacy.proto",
+		"shop.Status@shop/product.proto",
+		"shop.ProductService@shop/product.proto",
+		"shop.sensitive@shop/product.proto",
+		"shop.Order.table@shop/order.proto",
+	}
+	if strings.Join(elements, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Wrong package elements:\n%s", strings.Join(elements, "\n"))
+	}
+
+	if el := p.FindName("Order"); el == nil || el.File.FileName != "shop/order.proto" {
+		t.Fatalf("Order not found in the package")
+	}
+	if p.FindName("sensitive") != nil {
+		t.Fatalf("Extensions are not top-level elements")
+	}
+
+	var problems []string
+	for _, pr := range p.Problems {
+		problems = append(problems, pr.String())
+	}
+	expectedProblems := []string{
+		"'shop.Product' is defined more than once, in 'shop/product.proto', 'shop/legacy.proto'",
+		"Files disagree on option 'go_package': 'example.com/shop' in 'shop/product.proto', 'example.com/shop' in 'shop/order.proto', 'example.com/legacy' in 'shop/legacy.proto'",
+	}
+	if strings.Join(problems, "\n") != strings.Join(expectedProblems, "\n") {
+		t.Fatalf("Wrong problems:\n%s", strings.Join(problems, "\n"))
+	}
+
+	// the same name in another package is not a duplicate
+	if other := fs.Package("other"); len(other.Problems) != 0 {
+		t.Fatalf("Package 'other' should have no problems: %v", other.Problems)
+	}
+	if fs.Package("missing") != nil {
+		t.Fatalf("Package without files should be nil")
+	}
+}
@@ -23,7 +23,7 @@ func Parse(r io.Reader) (*ProtoFile, error) {
 	}
 
 	if v.Err() != nil {
-		return nil, err
+		return nil, v.Err()
 	}
 
 	return protofile, nil
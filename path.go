@@ -0,0 +1,519 @@
+package fproto
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Field numbers of descriptor.proto used in element paths
+const (
+	pathFileMessage   = 4
+	pathFileEnum      = 5
+	pathFileService   = 6
+	pathFileExtension = 7
+	pathFileOptions   = 8
+
+	pathMessageField          = 2
+	pathMessageNested         = 3
+	pathMessageEnum           = 4
+	pathMessageExtensionRange = 5
+	pathMessageExtension      = 6
+	pathMessageOptions        = 7
+	pathMessageOneof          = 8
+	pathMessageReservedRange  = 9
+
+	pathFieldDefault  = 7
+	pathFieldOptions  = 8
+	pathFieldJSONName = 10
+
+	pathOneofOptions = 2
+
+	pathEnumValue   = 2
+	pathEnumOptions = 3
+
+	pathEnumValueOptions = 3
+
+	pathServiceMethod  = 2
+	pathServiceOptions = 3
+
+	pathMethodOptions = 4
+
+	pathUninterpretedOption = 999
+)
+
+// Returns the path of the element in its file, following the descriptor.proto
+// numbering used by the SourceCodeInfo locations of protoc. The file path is empty.
+//
+// Fields of oneofs are numbered in the message fields, and map entry messages
+// are numbered after the declared nested messages, like ToFileDescriptorProto
+// generates them. All the extend blocks of a scope have the same path, and
+// their fields are numbered together. Custom options are numbered as
+// uninterpreted options.
+//
+// Returns nil if the element is not part of a file.
+func PathOf(element FProtoElement) []int32 {
+	switch el := element.(type) {
+	case *ProtoFile:
+		return []int32{}
+	case *MessageElement:
+		if el.IsExtend {
+			switch p := el.Parent.(type) {
+			case *ProtoFile:
+				return []int32{pathFileExtension}
+			case *MessageElement:
+				return appendElementPath(PathOf(p), pathMessageExtension)
+			}
+			return nil
+		}
+		switch p := el.Parent.(type) {
+		case *ProtoFile:
+			return appendElementPath([]int32{}, pathFileMessage, indexOfMessage(p.Messages, el))
+		case *MessageElement:
+			return appendElementPath(PathOf(p), pathMessageNested, indexOfMessage(p.Messages, el))
+		}
+	case *EnumElement:
+		switch p := el.Parent.(type) {
+		case *ProtoFile:
+			return appendElementPath([]int32{}, pathFileEnum, indexOfEnum(p.Enums, el))
+		case *MessageElement:
+			return appendElementPath(PathOf(p), pathMessageEnum, indexOfEnum(p.Enums, el))
+		}
+	case *EnumConstantElement:
+		if p, ok := el.Parent.(*EnumElement); ok {
+			for i, c := range p.EnumConstants {
+				if c == el {
+					return appendElementPath(PathOf(p), pathEnumValue, i)
+				}
+			}
+		}
+	case *ServiceElement:
+		if p, ok := el.Parent.(*ProtoFile); ok {
+			for i, s := range p.Services {
+				if s == el {
+					return []int32{pathFileService, int32(i)}
+				}
+			}
+		}
+	case *RPCElement:
+		if p, ok := el.Parent.(*ServiceElement); ok {
+			for i, rpc := range p.RPCs {
+				if rpc == el {
+					return appendElementPath(PathOf(p), pathServiceMethod, i)
+				}
+			}
+		}
+	case *MapFieldElement:
+		return PathOf(el.FieldElement)
+	case *FieldElement:
+		return fieldPath(el)
+	case *OneOfFieldElement:
+		if p, ok := el.Parent.(*MessageElement); ok {
+			for i, o := range messageOneofs(p) {
+				if o == el {
+					return appendElementPath(PathOf(p), pathMessageOneof, i)
+				}
+			}
+		}
+	case *ExtensionsElement:
+		if p, ok := el.Parent.(*MessageElement); ok {
+			for i, e := range p.Extensions {
+				if e == el {
+					return appendElementPath(PathOf(p), pathMessageExtensionRange, i)
+				}
+			}
+		}
+	case *ReservedRangeElement:
+		if p, ok := el.Parent.(*MessageElement); ok {
+			for i, r := range p.ReservedRanges {
+				if r == el {
+					return appendElementPath(PathOf(p), pathMessageReservedRange, i)
+				}
+			}
+		}
+	case *OptionElement:
+		return optionPath(el)
+	}
+	return nil
+}
+
+// Returns the element at the path of the file, or nil if there is none. See PathOf.
+//
+// The paths of map entry messages and their fields return the MapFieldElement.
+func ElementAt(file *ProtoFile, path []int32) FProtoElement {
+	var cur FProtoElement = file
+	for len(path) > 0 {
+		var next FProtoElement
+		consumed := 2
+		idx := -1
+		if len(path) > 1 {
+			idx = int(path[1])
+		}
+
+		switch el := cur.(type) {
+		case *ProtoFile:
+			switch path[0] {
+			case pathFileMessage:
+				next = messageAt(el.Messages, idx)
+			case pathFileEnum:
+				next = enumAt(el.Enums, idx)
+			case pathFileService:
+				if idx >= 0 && idx < len(el.Services) {
+					next = el.Services[idx]
+				}
+			case pathFileExtension:
+				next, consumed = extensionAt(el.ExtendMessages, path)
+			case pathFileOptions:
+				next, consumed = optionAt(el, el.Options, path)
+			}
+		case *MessageElement:
+			switch path[0] {
+			case pathMessageField:
+				fields := messageFields(el)
+				if idx >= 0 && idx < len(fields) {
+					next = fields[idx]
+				}
+			case pathMessageNested:
+				if idx >= len(el.Messages) {
+					// map entry messages, after the declared ones
+					next = mapFieldAt(el, idx-len(el.Messages))
+					if next != nil && len(path) > 2 {
+						return next
+					}
+				} else {
+					next = messageAt(el.Messages, idx)
+				}
+			case pathMessageEnum:
+				next = enumAt(el.Enums, idx)
+			case pathMessageExtensionRange:
+				if idx >= 0 && idx < len(el.Extensions) {
+					next = el.Extensions[idx]
+				}
+			case pathMessageExtension:
+				next, consumed = extensionAt(el.ExtendMessages, path)
+			case pathMessageOptions:
+				next, consumed = optionAt(el, el.Options, path)
+			case pathMessageOneof:
+				oneofs := messageOneofs(el)
+				if idx >= 0 && idx < len(oneofs) {
+					next = oneofs[idx]
+				}
+			case pathMessageReservedRange:
+				if idx >= 0 && idx < len(el.ReservedRanges) {
+					next = el.ReservedRanges[idx]
+				}
+			}
+		case *FieldElement, *MapFieldElement:
+			next, consumed = fieldOptionAt(el, path)
+		case *OneOfFieldElement:
+			if path[0] == pathOneofOptions {
+				next, consumed = optionAt(el, el.Options, path)
+			}
+		case *EnumElement:
+			switch path[0] {
+			case pathEnumValue:
+				if idx >= 0 && idx < len(el.EnumConstants) {
+					next = el.EnumConstants[idx]
+				}
+			case pathEnumOptions:
+				next, consumed = optionAt(el, el.Options, path)
+			}
+		case *EnumConstantElement:
+			if path[0] == pathEnumValueOptions {
+				next, consumed = optionAt(el, el.Options, path)
+			}
+		case *ServiceElement:
+			switch path[0] {
+			case pathServiceMethod:
+				if idx >= 0 && idx < len(el.RPCs) {
+					next = el.RPCs[idx]
+				}
+			case pathServiceOptions:
+				next, consumed = optionAt(el, el.Options, path)
+			}
+		case *RPCElement:
+			if path[0] == pathMethodOptions {
+				next, consumed = optionAt(el, el.Options, path)
+			}
+		}
+
+		if next == nil || consumed > len(path) {
+			return nil
+		}
+		cur = next
+		path = path[consumed:]
+	}
+	return cur
+}
+
+func fieldPath(f *FieldElement) []int32 {
+	switch p := f.Parent.(type) {
+	case *OneOfFieldElement:
+		if m, ok := p.Parent.(*MessageElement); ok {
+			return messageFieldPath(m, f)
+		}
+	case *MessageElement:
+		if !p.IsExtend {
+			return messageFieldPath(p, f)
+		}
+		var extends []*MessageElement
+		var scopePath []int32
+		switch s := p.Parent.(type) {
+		case *ProtoFile:
+			extends, scopePath = s.ExtendMessages, []int32{pathFileExtension}
+		case *MessageElement:
+			extends, scopePath = s.ExtendMessages, appendElementPath(PathOf(s), pathMessageExtension)
+		}
+		for i, ef := range extensionFields(extends) {
+			if ef == f {
+				return appendElementPath(scopePath, i)
+			}
+		}
+	}
+	return nil
+}
+
+func messageFieldPath(m *MessageElement, f *FieldElement) []int32 {
+	for i, fld := range messageFields(m) {
+		if fld == f {
+			return appendElementPath(PathOf(m), pathMessageField, i)
+		}
+		if mf, ok := fld.(*MapFieldElement); ok && mf.FieldElement == f {
+			return appendElementPath(PathOf(m), pathMessageField, i)
+		}
+	}
+	return nil
+}
+
+func optionPath(o *OptionElement) []int32 {
+	switch p := o.Parent.(type) {
+	case *FieldElement:
+		return fieldOptionPath(PathOf(p), p.Options, o)
+	case *MapFieldElement:
+		return fieldOptionPath(PathOf(p), p.FieldElement.Options, o)
+	}
+
+	opts, number := newDescriptorOptions(o.Parent)
+	if opts == nil {
+		return nil
+	}
+	sub := elementOptionPath(opts, elementOptions(o.Parent), o)
+	if sub == nil {
+		return nil
+	}
+	parentPath := PathOf(o.Parent)
+	if parentPath == nil {
+		return nil
+	}
+	return append(appendPath(parentPath, number), sub...)
+}
+
+// Field default and json_name options are descriptor fields.
+func fieldOptionPath(fieldPath []int32, options []*OptionElement, o *OptionElement) []int32 {
+	if fieldPath == nil {
+		return nil
+	}
+	var rest []*OptionElement
+	for _, fo := range options {
+		switch {
+		case fo == o && isFieldDefaultOption(o):
+			return appendElementPath(fieldPath, pathFieldDefault)
+		case fo == o && isFieldJSONNameOption(o):
+			return appendElementPath(fieldPath, pathFieldJSONName)
+		case !isFieldDefaultOption(fo) && !isFieldJSONNameOption(fo):
+			rest = append(rest, fo)
+		}
+	}
+	sub := elementOptionPath(&descriptorpb.FieldOptions{}, rest, o)
+	if sub == nil {
+		return nil
+	}
+	return append(appendPath(fieldPath, pathFieldOptions), sub...)
+}
+
+// Path of the option inside the options message.
+func elementOptionPath(opts proto.Message, options []*OptionElement, o *OptionElement) []int32 {
+	custom := 0
+	for _, eo := range options {
+		if isStandardOption(opts, eo) {
+			if eo == o {
+				fd := opts.ProtoReflect().Descriptor().Fields().ByName(protoreflect.Name(o.Name))
+				return []int32{int32(fd.Number())}
+			}
+			continue
+		}
+		if eo == o {
+			return []int32{pathUninterpretedOption, int32(custom)}
+		}
+		custom++
+	}
+	return nil
+}
+
+func fieldOptionAt(field FProtoElement, path []int32) (FProtoElement, int) {
+	var options []*OptionElement
+	switch f := field.(type) {
+	case *FieldElement:
+		options = f.Options
+	case *MapFieldElement:
+		options = f.FieldElement.Options
+	}
+
+	var rest []*OptionElement
+	for _, o := range options {
+		switch {
+		case isFieldDefaultOption(o):
+			if path[0] == pathFieldDefault {
+				return o, 1
+			}
+		case isFieldJSONNameOption(o):
+			if path[0] == pathFieldJSONName {
+				return o, 1
+			}
+		default:
+			rest = append(rest, o)
+		}
+	}
+	if path[0] != pathFieldOptions {
+		return nil, 0
+	}
+	return optionAtPath(&descriptorpb.FieldOptions{}, rest, path[1:])
+}
+
+func optionAt(parent FProtoElement, options []*OptionElement, path []int32) (FProtoElement, int) {
+	opts, _ := newDescriptorOptions(parent)
+	if opts == nil {
+		return nil, 0
+	}
+	return optionAtPath(opts, options, path[1:])
+}
+
+// Finds the option by the path inside the options message, returning the path items used.
+func optionAtPath(opts proto.Message, options []*OptionElement, path []int32) (FProtoElement, int) {
+	if len(path) == 0 {
+		return nil, 0
+	}
+	custom := 0
+	for _, o := range options {
+		if isStandardOption(opts, o) {
+			fd := opts.ProtoReflect().Descriptor().Fields().ByName(protoreflect.Name(o.Name))
+			if int32(fd.Number()) == path[0] {
+				return o, 2
+			}
+			continue
+		}
+		if path[0] == pathUninterpretedOption && len(path) > 1 && path[1] == int32(custom) {
+			return o, 3
+		}
+		custom++
+	}
+	return nil, 0
+}
+
+// The first extend block, or the extension field at the index.
+func extensionAt(extends []*MessageElement, path []int32) (FProtoElement, int) {
+	if len(path) == 1 {
+		if len(extends) > 0 {
+			return extends[0], 1
+		}
+		return nil, 0
+	}
+	fields := extensionFields(extends)
+	if path[1] >= 0 && int(path[1]) < len(fields) {
+		return fields[path[1]], 2
+	}
+	return nil, 0
+}
+
+func mapFieldAt(m *MessageElement, idx int) FProtoElement {
+	i := 0
+	for _, fld := range m.Fields {
+		if mf, ok := fld.(*MapFieldElement); ok {
+			if i == idx {
+				return mf
+			}
+			i++
+		}
+	}
+	return nil
+}
+
+func messageAt(list []*MessageElement, idx int) FProtoElement {
+	if idx >= 0 && idx < len(list) {
+		return list[idx]
+	}
+	return nil
+}
+
+func enumAt(list []*EnumElement, idx int) FProtoElement {
+	if idx >= 0 && idx < len(list) {
+		return list[idx]
+	}
+	return nil
+}
+
+// Message fields in descriptor order, with oneof fields flattened.
+func messageFields(m *MessageElement) []FieldElementTag {
+	var ret []FieldElementTag
+	for _, fld := range m.Fields {
+		if oneof, ok := fld.(*OneOfFieldElement); ok {
+			ret = append(ret, oneof.Fields...)
+		} else {
+			ret = append(ret, fld)
+		}
+	}
+	return ret
+}
+
+func messageOneofs(m *MessageElement) []*OneOfFieldElement {
+	var ret []*OneOfFieldElement
+	for _, fld := range m.Fields {
+		if oneof, ok := fld.(*OneOfFieldElement); ok {
+			ret = append(ret, oneof)
+		}
+	}
+	return ret
+}
+
+func extensionFields(extends []*MessageElement) []*FieldElement {
+	var ret []*FieldElement
+	for _, ext := range extends {
+		for _, fld := range ext.Fields {
+			if f, ok := fld.(*FieldElement); ok {
+				ret = append(ret, f)
+			}
+		}
+	}
+	return ret
+}
+
+func indexOfMessage(list []*MessageElement, m *MessageElement) int {
+	for i, lm := range list {
+		if lm == m {
+			return i
+		}
+	}
+	return -1
+}
+
+func indexOfEnum(list []*EnumElement, e *EnumElement) int {
+	for i, le := range list {
+		if le == e {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns nil if the parent path is nil or any index is not found.
+func appendElementPath(path []int32, items ...int) []int32 {
+	if path == nil {
+		return nil
+	}
+	for _, i := range items {
+		if i < 0 {
+			return nil
+		}
+	}
+	return appendPath(path, items...)
+}
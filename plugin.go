@@ -0,0 +1,153 @@
+package fproto
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+const defaultPluginFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+
+// Plugin is a protoc plugin invocation, with the request files rebuilt as
+// ProtoFiles. Generators add the output files to it.
+type Plugin struct {
+	Request *pluginpb.CodeGeneratorRequest
+
+	// All the request files, linked
+	FileSet *FileSet
+	Links   *Links
+
+	// Files to generate, in the request order
+	Files []*ProtoFile
+
+	// The --<name>_opt / --<name>_out parameter
+	Parameter string
+
+	// Features reported to protoc, proto3 optional is supported by default
+	SupportedFeatures uint64
+
+	response []*pluginpb.CodeGeneratorResponse_File
+}
+
+// Creates the plugin from the request, loading and linking all its files.
+func NewPlugin(req *pluginpb.CodeGeneratorRequest) (*Plugin, error) {
+	fs, err := NewFileSetFromDescriptorSet(&descriptorpb.FileDescriptorSet{File: req.ProtoFile})
+	if err != nil {
+		return nil, err
+	}
+	links, err := Link(fs)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &Plugin{
+		Request:           req,
+		FileSet:           fs,
+		Links:             links,
+		Parameter:         req.GetParameter(),
+		SupportedFeatures: defaultPluginFeatures,
+	}
+	for _, fn := range req.FileToGenerate {
+		f := fs.FindFile(fn)
+		if f == nil {
+			return nil, &ImportNotFound{Path: fn}
+		}
+		ret.Files = append(ret.Files, f)
+	}
+	return ret, nil
+}
+
+// Returns the comma separated "name=value" parameters. Parameters without
+// a value are returned with an empty value.
+func (p *Plugin) Params() map[string]string {
+	ret := make(map[string]string)
+	for _, param := range strings.Split(p.Parameter, ",") {
+		if param == "" {
+			continue
+		}
+		if i := strings.Index(param, "="); i >= 0 {
+			ret[param[:i]] = param[i+1:]
+		} else {
+			ret[param] = ""
+		}
+	}
+	return ret
+}
+
+// Adds a generated file. The name is relative to the output directory.
+func (p *Plugin) AddFile(name, content string) {
+	p.response = append(p.response, &pluginpb.CodeGeneratorResponse_File{
+		Name:    proto.String(name),
+		Content: proto.String(content),
+	})
+}
+
+// Adds content to be inserted at an insertion point of a file generated by
+// another plugin in the same protoc run.
+func (p *Plugin) AddInsertion(name, insertionPoint, content string) {
+	p.response = append(p.response, &pluginpb.CodeGeneratorResponse_File{
+		Name:           proto.String(name),
+		InsertionPoint: proto.String(insertionPoint),
+		Content:        proto.String(content),
+	})
+}
+
+// Returns the response with the generated files. If err is not nil, the
+// response only has the error, which protoc reports as a generator failure.
+func (p *Plugin) Response(err error) *pluginpb.CodeGeneratorResponse {
+	ret := &pluginpb.CodeGeneratorResponse{
+		SupportedFeatures: proto.Uint64(p.SupportedFeatures),
+	}
+	if err != nil {
+		ret.Error = proto.String(err.Error())
+		return ret
+	}
+	ret.File = p.response
+	return ret
+}
+
+// Reads the CodeGeneratorRequest from r, calls the generator and writes the
+// CodeGeneratorResponse to w.
+//
+// Errors from loading the request files or from the generator are reported in
+// the response. An error is only returned if the request or the response could
+// not be read or written.
+func RunPluginIO(r io.Reader, w io.Writer, generate func(*Plugin) error) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	req := &pluginpb.CodeGeneratorRequest{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return err
+	}
+
+	var resp *pluginpb.CodeGeneratorResponse
+	if p, err := NewPlugin(req); err != nil {
+		resp = (&Plugin{SupportedFeatures: defaultPluginFeatures}).Response(err)
+	} else {
+		resp = p.Response(generate(p))
+	}
+
+	out, err := proto.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// Runs as a protoc plugin using stdin and stdout, exiting on failure.
+// See RunPluginIO.
+func RunPlugin(generate func(*Plugin) error) {
+	if err := RunPluginIO(os.Stdin, os.Stdout, generate); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,110 @@
+package fproto
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func testPluginRequest(t *testing.T, parameter string) *pluginpb.CodeGeneratorRequest {
+	fs := NewFileSet(testdescriptorset)
+	if err := fs.Load("shop/product.proto"); err != nil {
+		t.Fatalf("Error loading proto file: %v", err)
+	}
+	links, err := Link(fs)
+	if err != nil {
+		t.Fatalf("Error linking: %v", err)
+	}
+	return &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"shop/product.proto"},
+		Parameter:      proto.String(parameter),
+		ProtoFile:      fs.ToFileDescriptorSet(links).File,
+	}
+}
+
+func TestPlugin(t *testing.T) {
+	data, err := proto.Marshal(testPluginRequest(t, "prefix=gen,verbose"))
+	if err != nil {
+		t.Fatalf("Error marshaling request: %v", err)
+	}
+
+	var out bytes.Buffer
+	err = RunPluginIO(bytes.NewReader(data), &out, func(p *Plugin) error {
+		if p.Params()["prefix"] != "gen" {
+			return fmt.Errorf("wrong parameters: %v", p.Params())
+		}
+		for _, f := range p.Files {
+			p.AddFile(p.Params()["prefix"]+"/"+f.FileName+".txt", f.Messages[0].Comment.Lines[0])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error running plugin: %v", err)
+	}
+
+	resp := &pluginpb.CodeGeneratorResponse{}
+	if err := proto.Unmarshal(out.Bytes(), resp); err != nil {
+		t.Fatalf("Error reading response: %v", err)
+	}
+	if resp.Error != nil {
+		t.Fatalf("Generator error: %s", resp.GetError())
+	}
+	if len(resp.File) != 1 || resp.File[0].GetName() != "gen/shop/product.proto.txt" || resp.File[0].GetContent() != "A product" {
+		t.Fatalf("Wrong generated files: %v", resp.File)
+	}
+	if resp.GetSupportedFeatures()&uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL) == 0 {
+		t.Fatalf("Proto3 optional should be supported")
+	}
+}
+
+// Runs as a plugin when the test binary is run by the plugin runner.
+func TestHelperPlugin(t *testing.T) {
+	if os.Getenv("FPROTO_TEST_PLUGIN") != "1" {
+		return
+	}
+	RunPlugin(func(p *Plugin) error {
+		for _, f := range p.Files {
+			name := strings.TrimSuffix(f.FileName, ".proto") + ".txt"
+			p.AddFile(name, "messages:\n\t// @@protoc_insertion_point(messages)\n")
+			p.AddInsertion(name, "messages", f.Messages[0].Name+"\n")
+		}
+		return nil
+	})
+	os.Exit(0)
+}
+
+func TestPluginRunner(t *testing.T) {
+	fs := NewFileSet(testdescriptorset)
+	if err := fs.Load("shop/product.proto"); err != nil {
+		t.Fatalf("Error loading proto file: %v", err)
+	}
+
+	runner := &PluginRunner{
+		Path: os.Args[0],
+		Args: []string{"-test.run=TestHelperPlugin"},
+		Env:  []string{"FPROTO_TEST_PLUGIN=1"},
+	}
+	out := NewPluginOutput(t.TempDir())
+	if err := runner.Generate(context.Background(), fs, nil, out, "shop/product.proto"); err != nil {
+		t.Fatalf("Error running plugin: %v", err)
+	}
+	if err := out.WriteDir(); err != nil {
+		t.Fatalf("Error writing output: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(out.Dir, "shop", "product.txt"))
+	if err != nil {
+		t.Fatalf("Generated file not written: %v", err)
+	}
+	if string(data) != "messages:\n\tProduct\n\t// @@protoc_insertion_point(messages)\n" {
+		t.Fatalf("Insertion point not applied: %q", data)
+	}
+}
@@ -0,0 +1,250 @@
+package fproto
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// PluginRunner runs a protoc plugin executable, like protoc does.
+type PluginRunner struct {
+	// Plugin executable
+	Path string
+	// Extra command line arguments, protoc doesn't pass any
+	Args []string
+	// Parameter sent in the request, like "--<name>_opt"
+	Parameter string
+	// Extra environment variables, in the "name=value" format
+	Env []string
+}
+
+// Creates a runner for the plugin. A name without a path separator is
+// searched in PATH, with the "protoc-gen-" prefix added if missing, so "go"
+// runs "protoc-gen-go".
+func NewPluginRunner(name, parameter string) (*PluginRunner, error) {
+	path := name
+	if !strings.ContainsRune(name, filepath.Separator) && !strings.ContainsRune(name, '/') {
+		if !strings.HasPrefix(name, "protoc-gen-") {
+			name = "protoc-gen-" + name
+		}
+		var err error
+		if path, err = exec.LookPath(name); err != nil {
+			return nil, err
+		}
+	}
+	return &PluginRunner{
+		Path:      path,
+		Parameter: parameter,
+	}, nil
+}
+
+// Builds the request to generate the files, with all the loaded files as
+// descriptors. If links is nil, the files are linked first.
+func (s *FileSet) CodeGeneratorRequest(links *Links, parameter string, files ...string) (*pluginpb.CodeGeneratorRequest, error) {
+	if links == nil {
+		var err error
+		if links, err = Link(s); err != nil {
+			return nil, err
+		}
+	}
+	for _, fn := range files {
+		if s.FindFile(fn) == nil {
+			return nil, &ImportNotFound{Path: fn}
+		}
+	}
+
+	ret := &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: files,
+		ProtoFile:      s.ToFileDescriptorSet(links).File,
+	}
+	if parameter != "" {
+		ret.Parameter = proto.String(parameter)
+	}
+	return ret, nil
+}
+
+// Runs the plugin with the request. The runner parameter replaces the
+// request one, if set.
+//
+// If the plugin fails or its response has an error, a *PluginError is returned.
+func (r *PluginRunner) Run(ctx context.Context, req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	if r.Parameter != "" {
+		req = proto.Clone(req).(*pluginpb.CodeGeneratorRequest)
+		req.Parameter = proto.String(r.Parameter)
+	}
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, r.Path, r.Args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if len(r.Env) > 0 {
+		cmd.Env = append(os.Environ(), r.Env...)
+	}
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, &PluginError{Plugin: r.Path, Message: msg}
+	}
+
+	resp := &pluginpb.CodeGeneratorResponse{}
+	if err := proto.Unmarshal(stdout.Bytes(), resp); err != nil {
+		return nil, &PluginError{Plugin: r.Path, Message: fmt.Sprintf("invalid response: %v", err)}
+	}
+	if resp.Error != nil {
+		return nil, &PluginError{Plugin: r.Path, Message: resp.GetError()}
+	}
+	return resp, nil
+}
+
+// Builds the request for the files, runs the plugin and applies the response to the output.
+func (r *PluginRunner) Generate(ctx context.Context, fs *FileSet, links *Links, out *PluginOutput, files ...string) error {
+	req, err := fs.CodeGeneratorRequest(links, r.Parameter, files...)
+	if err != nil {
+		return err
+	}
+	resp, err := r.Run(ctx, req)
+	if err != nil {
+		return err
+	}
+	if err := out.Apply(resp); err != nil {
+		if perr, ok := err.(*PluginError); ok {
+			perr.Plugin = r.Path
+		}
+		return err
+	}
+	return nil
+}
+
+// PluginOutput has the files generated by one or more plugin runs, in order,
+// with the insertion points applied like protoc does.
+type PluginOutput struct {
+	// Directory where files not generated in this output are read from, to
+	// apply insertion points. Optional.
+	Dir string
+
+	Names []string
+	Files map[string]string
+}
+
+// Creates an output for files written to dir.
+func NewPluginOutput(dir string) *PluginOutput {
+	return &PluginOutput{
+		Dir:   dir,
+		Files: make(map[string]string),
+	}
+}
+
+// Applies the generated files of the response. Files without a name continue
+// the previous file, and files with an insertion point are inserted in a
+// file already generated, or existing in the output directory.
+func (o *PluginOutput) Apply(resp *pluginpb.CodeGeneratorResponse) error {
+	if resp.Error != nil {
+		return &PluginError{Message: resp.GetError()}
+	}
+	if o.Files == nil {
+		o.Files = make(map[string]string)
+	}
+
+	last := ""
+	for _, f := range resp.File {
+		name := f.GetName()
+		if name == "" {
+			if last == "" {
+				return &PluginError{Message: "first generated file has no name"}
+			}
+			o.Files[last] += f.GetContent()
+			continue
+		}
+		if !IsValidImportPath(name) {
+			return &PluginError{Message: fmt.Sprintf("invalid generated file name '%s'", name)}
+		}
+
+		if f.GetInsertionPoint() == "" {
+			if _, ok := o.Files[name]; ok {
+				return &PluginError{Message: fmt.Sprintf("file '%s' generated more than once", name)}
+			}
+			o.Names = append(o.Names, name)
+			o.Files[name] = f.GetContent()
+			last = name
+			continue
+		}
+
+		content, ok := o.Files[name]
+		if !ok && o.Dir != "" {
+			data, err := ioutil.ReadFile(filepath.Join(o.Dir, filepath.FromSlash(name)))
+			if err != nil {
+				return &PluginError{Message: fmt.Sprintf("insertion point target '%s' not found: %v", name, err)}
+			}
+			content = string(data)
+			o.Names = append(o.Names, name)
+			ok = true
+		}
+		if !ok {
+			return &PluginError{Message: fmt.Sprintf("insertion point target '%s' not generated", name)}
+		}
+		content, ok = insertAtPoint(content, f.GetInsertionPoint(), f.GetContent())
+		if !ok {
+			return &PluginError{Message: fmt.Sprintf("insertion point '%s' not found in '%s'", f.GetInsertionPoint(), name)}
+		}
+		o.Files[name] = content
+		last = name
+	}
+	return nil
+}
+
+// Writes all the files to the output directory.
+func (o *PluginOutput) WriteDir() error {
+	for _, name := range o.Names {
+		fn := filepath.Join(o.Dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(fn, []byte(o.Files[name]), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Inserts the text before the line with the insertion point, with the line indentation.
+func insertAtPoint(content, point, text string) (string, bool) {
+	idx := strings.Index(content, "@@protoc_insertion_point("+point+")")
+	if idx < 0 {
+		return "", false
+	}
+	lineStart := strings.LastIndex(content[:idx], "\n") + 1
+	line := content[lineStart:idx]
+	indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+
+	var b strings.Builder
+	b.WriteString(content[:lineStart])
+	for _, ln := range strings.SplitAfter(text, "\n") {
+		if ln == "" {
+			continue
+		}
+		if ln != "\n" {
+			b.WriteString(indent)
+		}
+		b.WriteString(ln)
+	}
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		b.WriteString("\n")
+	}
+	b.WriteString(content[lineStart:])
+	return b.String(), true
+}
@@ -62,3 +62,292 @@ func TestFindProtoFileName(t *testing.T) {
 		t.Fatalf("User.Address' parent name should be a 'User' but is %s", user_item.Name)
 	}
 }
+
+func TestInspect(t *testing.T) {
+	pfile, err := Parse(strings.NewReader(`
+syntax = "proto3";
+package p_inspect;
+option go_package = "myapp/proto/p_inspect";
+
+message User {
+	enum Kind {
+		HOME = 0 [deprecated = true];
+	}
+	int32 id = 1 [deprecated = true];
+	map<string, string> tags = 2;
+	oneof contact {
+		string email = 3;
+	}
+	reserved 10 to 20;
+}
+
+service UserService {
+	rpc GetUser(User) returns (User);
+}
+`))
+	if err != nil {
+		t.Fatalf("Error parsing proto file: %v", err)
+	}
+
+	var types []string
+	depth, maxDepth := 0, 0
+	Inspect(pfile, func(element FProtoElement) bool {
+		if element == nil {
+			depth--
+			return false
+		}
+		depth++
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+		types = append(types, element.ElementTypeName())
+		return true
+	})
+
+	expected := "PROTO FILE,OPTION,MESSAGE,FIELD,OPTION,MAP FIELD,ONEOF FIELD,FIELD,ENUM,ENUM CONSTANT,OPTION,RESERVED RANGE,SERVICE,RPC"
+	if strings.Join(types, ",") != expected {
+		t.Fatalf("Wrong visit order: %s", strings.Join(types, ","))
+	}
+	if depth != 0 || maxDepth != 5 {
+		t.Fatalf("Leave calls don't match enter calls: depth %d, max %d", depth, maxDepth)
+	}
+
+	// skip message subtrees
+	var names []string
+	Inspect(pfile, func(element FProtoElement) bool {
+		if element != nil {
+			names = append(names, element.ElementName())
+		}
+		_, isMessage := element.(*MessageElement)
+		return !isMessage
+	})
+	if strings.Join(names, ",") != ",go_package,User,UserService,GetUser" {
+		t.Fatalf("Message children should be skipped: %v", names)
+	}
+}
+
+func TestApply(t *testing.T) {
+	pfile, err := Parse(strings.NewReader(`
+syntax = "proto3";
+package p_apply;
+
+message User {
+	int32 id = 1;
+	string internal_note = 2;
+	oneof contact {
+		string email = 3;
+	}
+}
+
+enum Kind {
+	HOME = 0;
+}
+`))
+	if err != nil {
+		t.Fatalf("Error parsing proto file: %v", err)
+	}
+
+	var constants []string
+	Apply(pfile, func(c *Cursor) bool {
+		switch el := c.Element().(type) {
+		case *EnumConstantElement:
+			constants = append(constants, c.Parent().ElementName()+"."+el.Name)
+		case *FieldElement:
+			if strings.HasPrefix(el.Name, "internal_") {
+				c.Delete()
+			} else if el.Name == "email" {
+				c.InsertAfter(&FieldElement{Name: "phone", Type: "string", Tag: 4})
+			}
+		case *EnumElement:
+			c.Replace(&EnumElement{Name: "UserKind", EnumConstants: el.EnumConstants})
+			c.InsertBefore(&EnumElement{Name: "Status"})
+		}
+		return true
+	}, nil)
+
+	user := pfile.Messages[0]
+	if len(user.Fields) != 2 || user.Fields[1].FieldName() != "contact" {
+		t.Fatalf("Field not deleted: %v", user.Fields)
+	}
+	contact := user.Fields[1].(*OneOfFieldElement)
+	if len(contact.Fields) != 2 || contact.Fields[1].FieldName() != "phone" || contact.Fields[1].ParentElement() != contact {
+		t.Fatalf("Field not inserted in the oneof")
+	}
+	if len(pfile.Enums) != 2 || pfile.Enums[0].Name != "Status" || pfile.Enums[1].Name != "UserKind" ||
+		pfile.Enums[0].Parent != pfile || pfile.Enums[1].Parent != pfile || pfile.Enums[1].EnumConstants[0].Parent != pfile.Enums[1] {
+		t.Fatalf("Enum not replaced: %v", pfile.Enums)
+	}
+	// the children of the replacement are traversed
+	if strings.Join(constants, ",") != "UserKind.HOME" {
+		t.Fatalf("Wrong traversed constants: %v", constants)
+	}
+
+	// stop on the first message
+	var names []string
+	Apply(pfile, nil, func(c *Cursor) bool {
+		names = append(names, c.Element().ElementName())
+		_, isMessage := c.Element().(*MessageElement)
+		return !isMessage
+	})
+	if strings.Join(names, ",") != "Status,HOME,UserKind,id,email,phone,contact,User" {
+		t.Fatalf("Traversal not stopped: %v", names)
+	}
+}
+
+func TestNavigation(t *testing.T) {
+	pfile, err := Parse(strings.NewReader(`
+syntax = "proto3";
+package p_navigation;
+
+message User {
+	int32 id = 1;
+	oneof contact {
+		string email = 2 [deprecated = true];
+		string phone = 3;
+	}
+}
+`))
+	if err != nil {
+		t.Fatalf("Error parsing proto file: %v", err)
+	}
+
+	user := pfile.Messages[0]
+	contact := user.Fields[1].(*OneOfFieldElement)
+	email := contact.Fields[0].(*FieldElement)
+	option := email.Options[0]
+
+	if EnclosingMessage(option) != user || EnclosingFile(option) != pfile || Enclosing[*OneOfFieldElement](option) != contact {
+		t.Fatalf("Wrong enclosing elements")
+	}
+	if Depth(option) != 4 || len(Ancestors(option)) != 4 || Ancestors(option)[0] != email {
+		t.Fatalf("Wrong ancestors")
+	}
+	if Index(contact) != 1 || NextSibling(email) != contact.Fields[1] || PrevSibling(email) != nil || NextSibling(contact) != nil {
+		t.Fatalf("Wrong siblings")
+	}
+	if len(Children(user)) != 2 || len(ChildrenOf[*OneOfFieldElement](user)) != 1 {
+		t.Fatalf("Wrong children")
+	}
+	if fields := DescendantsOf[*FieldElement](pfile); len(fields) != 3 || fields[2].Name != "phone" {
+		t.Fatalf("Wrong descendants")
+	}
+}
+
+func TestAllElements(t *testing.T) {
+	pfile, err := Parse(strings.NewReader(testfile))
+	if err != nil {
+		t.Fatalf("Error parsing proto file: %v", err)
+	}
+
+	var names []string
+	for m := range AllMessages(pfile) {
+		names = append(names, m.Name)
+	}
+	if strings.Join(names, ",") != "User,Address" {
+		t.Fatalf("Wrong messages: %v", names)
+	}
+
+	names = nil
+	for f := range AllFields(pfile, func(f FieldElementTag) bool { return f.FirstFieldTag() > 1 }) {
+		names = append(names, f.FieldName())
+		if len(names) == 3 {
+			break
+		}
+	}
+	if strings.Join(names, ",") != "name,email,address" {
+		t.Fatalf("Wrong filtered fields: %v", names)
+	}
+
+	count := 0
+	for range Filter(AllOptions(pfile), func(o *OptionElement) bool { return o.Name == "go_package" }) {
+		count++
+	}
+	if count != 1 {
+		t.Fatalf("Wrong options count: %d", count)
+	}
+
+	// the iterators follow the Walk order
+	var walked, iterated []FProtoElement
+	Inspect(pfile, func(el FProtoElement) bool {
+		if o, ok := el.(*OptionElement); ok {
+			walked = append(walked, o)
+		}
+		return true
+	})
+	for o := range AllOptions(pfile) {
+		iterated = append(iterated, o)
+	}
+	if len(walked) == 0 || len(walked) != len(iterated) {
+		t.Fatalf("Iterators should return the same options as Walk")
+	}
+	for i := range walked {
+		if walked[i] != iterated[i] {
+			t.Fatalf("Iterators should follow the Walk order")
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	pfile, err := Parse(strings.NewReader(`
+syntax = "proto3";
+package p_query;
+
+message User {
+	option (db.table) = "users";
+
+	string user_id = 1;
+	int32 group_id = 2;
+	string name = 3 [deprecated = true];
+	map<string, string> tags = 4;
+}
+
+message Event {
+	option (audit).level = 5;
+	option (audit) = { level: 7 };
+
+	string event_id = 1;
+}
+
+service UserService {
+	rpc GetUser(User) returns (User);
+	rpc WatchUsers(User) returns (stream User);
+}
+`))
+	if err != nil {
+		t.Fatalf("Error parsing proto file: %v", err)
+	}
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`message[@(db.table)] field#*_id[type=string]`, "user_id"},
+		{`message[@(db.table)="users"] > field[type!=string]`, "group_id"},
+		{`rpc[server-streaming]`, "WatchUsers"},
+		{`field[@deprecated], map[key=string]`, "name,tags"},
+		{`message#E* field, service > rpc[client-streaming]`, "event_id"},
+		{`* > option[value=users]`, "db.table"},
+		{`message[@(audit).level=7], message[@(audit).level=5]`, "Event"},
+		{`message[@(audit).level!=7]`, "User"},
+		{`field[@(deprecated)]`, ""},
+	}
+	for _, test := range tests {
+		elements, err := Select(pfile, test.query)
+		if err != nil {
+			t.Fatalf("Error in query %s: %v", test.query, err)
+		}
+		var names []string
+		for _, el := range elements {
+			names = append(names, el.ElementName())
+		}
+		if strings.Join(names, ",") != test.expected {
+			t.Fatalf("Wrong result for query %s: %v", test.query, names)
+		}
+	}
+
+	for _, query := range []string{"", "messages", "field[type=", "field >", "rpc[", "field#[ab"} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Fatalf("Query '%s' should be invalid", query)
+		}
+	}
+}
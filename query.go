@@ -0,0 +1,517 @@
+package fproto
+
+import (
+	"fmt"
+	"iter"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Query selects elements of the element tree, using a syntax similar to CSS selectors.
+//
+// A query is a comma separated list of selectors. A selector is a list of
+// element matchers separated by a space, for any ancestor, or by ">", for the
+// direct parent. Each matcher has an optional element type, an optional name
+// glob after "#", and any number of attribute tests in brackets:
+//
+//	message[@(db.table)] field#*_id[type=string]
+//	rpc[server-streaming], rpc[client-streaming]
+//	enum > value[@deprecated=true]
+//
+// Element types are file, message, extend, field (including map fields), map,
+// oneof, enum, value (enum constants), service, rpc, option, extensions,
+// reserved and "*" for any.
+//
+// Attributes are name, type and key (of fields and map fields), label
+// (repeated, optional, required or empty), tag, request and response (of
+// RPCs), client-streaming, server-streaming, syntax and package (of files),
+// and value (of options). Options of the element are tested with "@" and the
+// option name as in the source, like [@deprecated] or [@(my.option).sub=value],
+// so [@(deprecated)] is a custom option, not the deprecated one. Options set
+// more than once match if any of the values does.
+//
+// "[attr]" tests that the attribute is set and not false, "[attr=glob]" that
+// it matches the glob, and "[attr!=glob]" that it doesn't. Values can be
+// quoted with double quotes.
+type Query struct {
+	Source    string
+	selectors []*querySelector
+}
+
+type querySelector struct {
+	steps []*queryStep
+}
+
+const (
+	queryDescendant = iota
+	queryChild
+)
+
+type queryStep struct {
+	combinator  int // relation to the previous step
+	elementType string
+	name        string
+	attrs       []*queryAttr
+}
+
+type queryAttr struct {
+	name  string
+	op    string // "", "=" or "!="
+	value string
+}
+
+// Parses the query. Returns a *QueryError if it is not valid.
+func ParseQuery(src string) (*Query, error) {
+	p := &queryParser{src: src}
+	ret := &Query{Source: src}
+	for {
+		sel, err := p.parseSelector()
+		if err != nil {
+			return nil, err
+		}
+		ret.selectors = append(ret.selectors, sel)
+		p.skipSpace()
+		if p.eof() {
+			break
+		}
+		if p.peek() != ',' {
+			return nil, p.errorf("expected ','")
+		}
+		p.pos++
+	}
+	return ret, nil
+}
+
+// Parses the query and returns the matching elements of the tree. See Query.Select.
+func Select(root FProtoElement, query string) ([]FProtoElement, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return q.Select(root), nil
+}
+
+// Returns the elements of the tree matching the query, including the root, in Walk order.
+func (q *Query) Select(root FProtoElement) []FProtoElement {
+	var ret []FProtoElement
+	for el := range q.All(root) {
+		ret = append(ret, el)
+	}
+	return ret
+}
+
+// Returns the elements of the tree matching the query, including the root, in Walk order.
+func (q *Query) All(root FProtoElement) iter.Seq[FProtoElement] {
+	return func(yield func(FProtoElement) bool) {
+		inspectSeq(root, func(el FProtoElement) bool {
+			if q.Match(el) {
+				return yield(el)
+			}
+			return true
+		})
+	}
+}
+
+// Returns whether the element matches any of the query selectors.
+func (q *Query) Match(element FProtoElement) bool {
+	for _, sel := range q.selectors {
+		if sel.match(len(sel.steps)-1, element) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *Query) String() string {
+	return q.Source
+}
+
+func (s *querySelector) match(i int, element FProtoElement) bool {
+	step := s.steps[i]
+	if !step.match(element) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	if step.combinator == queryChild {
+		parent := element.ParentElement()
+		return parent != nil && s.match(i-1, parent)
+	}
+	for parent := element.ParentElement(); parent != nil; parent = parent.ParentElement() {
+		if s.match(i-1, parent) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *queryStep) match(element FProtoElement) bool {
+	if s.elementType != "" && s.elementType != "*" && !queryTypeMatch(s.elementType, element) {
+		return false
+	}
+	if s.name != "" {
+		if ok, _ := path.Match(s.name, element.ElementName()); !ok {
+			return false
+		}
+	}
+	for _, a := range s.attrs {
+		if !a.match(element) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *queryAttr) match(element FProtoElement) bool {
+	if !strings.HasPrefix(a.name, "@") {
+		value, ok := queryAttrValue(element, a.name)
+		return a.matchValue(value, ok)
+	}
+
+	// an option can be set more than once, like "(my.option).sub = 1" and
+	// "(my.option) = { sub: 2 }": "!=" must hold for all the values, the other
+	// tests for any of them
+	values := queryOptionValues(element, a.name[1:])
+	if len(values) == 0 {
+		return a.matchValue("", false)
+	}
+	for _, value := range values {
+		if match := a.matchValue(value, true); match != (a.op == "!=") {
+			return match
+		}
+	}
+	return a.op == "!="
+}
+
+func (a *queryAttr) matchValue(value string, ok bool) bool {
+	switch a.op {
+	case "=":
+		match, _ := path.Match(a.value, value)
+		return ok && match
+	case "!=":
+		match, _ := path.Match(a.value, value)
+		return !ok || !match
+	}
+	return ok && value != "false"
+}
+
+var queryElementTypes = []string{"file", "message", "extend", "field", "map", "oneof", "enum", "value",
+	"service", "rpc", "option", "extensions", "reserved", "*"}
+
+func queryTypeMatch(elementType string, element FProtoElement) bool {
+	switch el := element.(type) {
+	case *ProtoFile:
+		return elementType == "file"
+	case *MessageElement:
+		return elementType == "message" && !el.IsExtend || elementType == "extend" && el.IsExtend
+	case *FieldElement:
+		return elementType == "field"
+	case *MapFieldElement:
+		return elementType == "field" || elementType == "map"
+	case *OneOfFieldElement:
+		return elementType == "oneof"
+	case *EnumElement:
+		return elementType == "enum"
+	case *EnumConstantElement:
+		return elementType == "value"
+	case *ServiceElement:
+		return elementType == "service"
+	case *RPCElement:
+		return elementType == "rpc"
+	case *OptionElement:
+		return elementType == "option"
+	case *ExtensionsElement:
+		return elementType == "extensions"
+	case *ReservedRangeElement:
+		return elementType == "reserved"
+	}
+	return false
+}
+
+// Returns the attribute value, and false if the element doesn't have it.
+func queryAttrValue(element FProtoElement, name string) (string, bool) {
+	switch name {
+	case "name":
+		return element.ElementName(), true
+	}
+
+	switch el := element.(type) {
+	case *ProtoFile:
+		switch name {
+		case "syntax":
+			return el.Syntax, true
+		case "package":
+			return el.PackageName, true
+		}
+	case *FieldElement:
+		switch name {
+		case "type":
+			return el.Type, true
+		case "tag":
+			return strconv.Itoa(el.Tag), true
+		case "label":
+			switch {
+			case el.Repeated:
+				return "repeated", true
+			case el.Required:
+				return "required", true
+			case el.Optional:
+				return "optional", true
+			}
+			return "", true
+		}
+	case *MapFieldElement:
+		switch name {
+		case "type":
+			return el.Type, true
+		case "key":
+			return el.KeyType, true
+		case "tag":
+			return strconv.Itoa(el.Tag), true
+		case "label":
+			return "repeated", true
+		}
+	case *EnumConstantElement:
+		if name == "tag" {
+			return strconv.Itoa(el.Tag), true
+		}
+	case *RPCElement:
+		switch name {
+		case "request":
+			return el.RequestType, true
+		case "response":
+			return el.ResponseType, true
+		case "client-streaming":
+			return strconv.FormatBool(el.StreamsRequest), true
+		case "server-streaming":
+			return strconv.FormatBool(el.StreamsResponse), true
+		}
+	case *OptionElement:
+		if name == "value" && el.Value != nil {
+			return el.Value.Source, true
+		}
+	}
+	return "", false
+}
+
+// Returns the values of the options of the element with the name, like
+// "deprecated" or "(my.option).sub", including the fields of aggregated values.
+func queryOptionValues(element FProtoElement, name string) []string {
+	var ret []string
+	for _, o := range elementOptions(element) {
+		oname := queryOptionName(o)
+		if oname == name {
+			if o.Value == nil {
+				ret = append(ret, "")
+			} else {
+				ret = append(ret, o.Value.Source)
+			}
+			continue
+		}
+		// sub option of an aggregated value
+		if strings.HasPrefix(name, oname+".") {
+			if v, ok := o.AggregatedValues[strings.TrimPrefix(name, oname+".")]; ok {
+				ret = append(ret, v.Source)
+			}
+		}
+	}
+	return ret
+}
+
+// Returns the option name as in the source, with the parentheses.
+func queryOptionName(o *OptionElement) string {
+	if !o.IsParenthesized {
+		return o.Name
+	}
+	if o.NPName != "" {
+		return "(" + o.ParenthesizedName + ")." + o.NPName
+	}
+	return "(" + o.ParenthesizedName + ")"
+}
+
+type queryParser struct {
+	src string
+	pos int
+}
+
+func (p *queryParser) parseSelector() (*querySelector, error) {
+	ret := &querySelector{}
+	combinator := queryDescendant
+	for {
+		p.skipSpace()
+		step, err := p.parseStep()
+		if err != nil {
+			return nil, err
+		}
+		step.combinator = combinator
+		ret.steps = append(ret.steps, step)
+
+		hadSpace := p.skipSpace()
+		if p.eof() || p.peek() == ',' {
+			return ret, nil
+		}
+		if p.peek() == '>' {
+			p.pos++
+			combinator = queryChild
+		} else if hadSpace {
+			combinator = queryDescendant
+		} else {
+			return nil, p.errorf("unexpected '%c'", p.peek())
+		}
+	}
+}
+
+func (p *queryParser) parseStep() (*queryStep, error) {
+	ret := &queryStep{}
+	start := p.pos
+
+	if !p.eof() && p.peek() == '*' {
+		p.pos++
+		ret.elementType = "*"
+	} else if ident := p.readWhile(isQueryIdent); ident != "" {
+		if !containsStr(queryElementTypes, ident) {
+			p.pos = start
+			return nil, p.errorf("unknown element type '%s'", ident)
+		}
+		ret.elementType = ident
+	}
+
+	for !p.eof() {
+		switch p.peek() {
+		case '#':
+			p.pos++
+			ret.name = p.readWhile(func(c byte) bool {
+				return !isQuerySpace(c) && c != '[' && c != ',' && c != '>'
+			})
+			if ret.name == "" {
+				return nil, p.errorf("expected name")
+			}
+			if _, err := path.Match(ret.name, ""); err != nil {
+				return nil, p.errorf("invalid name glob '%s'", ret.name)
+			}
+			continue
+		case '[':
+			attr, err := p.parseAttr()
+			if err != nil {
+				return nil, err
+			}
+			ret.attrs = append(ret.attrs, attr)
+			continue
+		}
+		break
+	}
+
+	if p.pos == start {
+		if p.eof() {
+			return nil, p.errorf("expected selector")
+		}
+		return nil, p.errorf("unexpected '%c'", p.peek())
+	}
+	return ret, nil
+}
+
+func (p *queryParser) parseAttr() (*queryAttr, error) {
+	p.pos++ // [
+	p.skipSpace()
+
+	ret := &queryAttr{}
+	if !p.eof() && p.peek() == '@' {
+		ret.name = "@" + p.readWhile(func(c byte) bool {
+			return c != '=' && c != '!' && c != ']' && !isQuerySpace(c)
+		})[1:]
+	} else {
+		ret.name = p.readWhile(isQueryIdent)
+	}
+	if ret.name == "" || ret.name == "@" {
+		return nil, p.errorf("expected attribute name")
+	}
+	p.skipSpace()
+
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "!="):
+		ret.op = "!="
+	case strings.HasPrefix(p.src[p.pos:], "="):
+		ret.op = "="
+	}
+	if ret.op != "" {
+		p.pos += len(ret.op)
+		p.skipSpace()
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := path.Match(value, ""); err != nil {
+			return nil, p.errorf("invalid value glob '%s'", value)
+		}
+		ret.value = value
+		p.skipSpace()
+	}
+
+	if p.eof() || p.peek() != ']' {
+		return nil, p.errorf("expected ']'")
+	}
+	p.pos++
+	return ret, nil
+}
+
+func (p *queryParser) parseValue() (string, error) {
+	if p.eof() || p.peek() != '"' {
+		return p.readWhile(func(c byte) bool {
+			return c != ']' && !isQuerySpace(c)
+		}), nil
+	}
+
+	start := p.pos
+	for p.pos++; !p.eof() && p.peek() != '"'; p.pos++ {
+		if p.peek() == '\\' {
+			p.pos++
+		}
+	}
+	if p.eof() {
+		p.pos = start
+		return "", p.errorf("unterminated string")
+	}
+	p.pos++
+	value, err := strconv.Unquote(p.src[start:p.pos])
+	if err != nil {
+		p.pos = start
+		return "", p.errorf("invalid string")
+	}
+	return value, nil
+}
+
+func (p *queryParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *queryParser) peek() byte {
+	return p.src[p.pos]
+}
+
+func (p *queryParser) skipSpace() bool {
+	start := p.pos
+	p.readWhile(isQuerySpace)
+	return p.pos > start
+}
+
+func (p *queryParser) readWhile(f func(byte) bool) string {
+	start := p.pos
+	for !p.eof() && f(p.peek()) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return &QueryError{Query: p.src, Pos: p.pos, Message: fmt.Sprintf(format, args...)}
+}
+
+func isQuerySpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isQueryIdent(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
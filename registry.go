@@ -0,0 +1,134 @@
+package fproto
+
+import (
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Registry has the protoreflect descriptors of all the files of a FileSet,
+// to be used at runtime with dynamicpb, protojson and prototext.
+//
+// Custom options with a linked extension are set as extension fields of the
+// options, like protoc does. Other custom options are kept as uninterpreted
+// options.
+type Registry struct {
+	FileSet *FileSet
+	Files   *protoregistry.Files
+
+	types    *dynamicpb.Types
+	elements map[protoreflect.FullName]FProtoElement
+}
+
+// Builds the descriptors of all the files of the FileSet. If links is nil, the
+// files are linked first.
+func BuildRegistry(fs *FileSet, links *Links) (*Registry, error) {
+	if links == nil {
+		var err error
+		if links, err = Link(fs); err != nil {
+			return nil, err
+		}
+	}
+
+	files, err := protodesc.NewFiles(fs.ToFileDescriptorSet(links))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &Registry{
+		FileSet:  fs,
+		Files:    files,
+		elements: make(map[protoreflect.FullName]FProtoElement),
+	}
+	for _, f := range fs.Files {
+		ret.addElements(f)
+	}
+	return ret, nil
+}
+
+// Returns the message and extension types of all the files, using dynamic
+// messages. Can be used as resolver by protojson and prototext.
+func (r *Registry) Types() *dynamicpb.Types {
+	if r.types == nil {
+		r.types = dynamicpb.NewTypes(r.Files)
+	}
+	return r.types
+}
+
+// Creates a new empty dynamic message of the named message type.
+func (r *Registry) NewMessage(name string) (*dynamicpb.Message, error) {
+	md, err := r.Types().FindMessageByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, err
+	}
+	return dynamicpb.NewMessage(md.Descriptor()), nil
+}
+
+// Returns the element the descriptor was built from, or nil if not found.
+//
+// Map entry messages and their fields return the MapFieldElement, and
+// synthetic oneofs of proto3 optional fields return the FieldElement.
+func (r *Registry) ElementOf(d protoreflect.Descriptor) FProtoElement {
+	if fd, ok := d.(protoreflect.FileDescriptor); ok {
+		if f := r.FileSet.FindFile(fd.Path()); f != nil {
+			return f
+		}
+		return nil
+	}
+	return r.elements[d.FullName()]
+}
+
+// Returns the descriptor built from the element, or nil if the element has no
+// descriptor, like extend blocks and options.
+func (r *Registry) DescriptorOf(element FProtoElement) protoreflect.Descriptor {
+	switch el := element.(type) {
+	case *ProtoFile:
+		if fd, err := r.Files.FindFileByPath(el.FileName); err == nil {
+			return fd
+		}
+		return nil
+	case *MessageElement:
+		if el.IsExtend {
+			return nil
+		}
+	case *OptionElement, *ExtensionsElement, *ReservedRangeElement:
+		return nil
+	}
+
+	if d, err := r.Files.FindDescriptorByName(protoreflect.FullName(FullName(element))); err == nil {
+		return d
+	}
+	return nil
+}
+
+func (r *Registry) addElements(f *ProtoFile) {
+	add := func(name string, el FProtoElement) {
+		r.elements[protoreflect.FullName(name)] = el
+	}
+
+	Inspect(f, func(element FProtoElement) bool {
+		switch el := element.(type) {
+		case *MessageElement:
+			if !el.IsExtend {
+				add(FullName(el), el)
+			}
+		case *EnumElement, *EnumConstantElement, *ServiceElement, *RPCElement, *OneOfFieldElement:
+			add(FullName(el), el)
+		case *FieldElement:
+			add(FullName(el), el)
+			if f.Syntax == "proto3" && el.Optional {
+				add(joinName(scopeName(el.Parent), "_"+el.Name), el)
+			}
+		case *MapFieldElement:
+			add(FullName(el), el)
+			entry := joinName(scopeName(el.Parent), MapEntryName(el.Name))
+			add(entry, el)
+			add(entry+".key", el)
+			add(entry+".value", el)
+		case *OptionElement:
+			return false
+		}
+		return true
+	})
+}
@@ -0,0 +1,80 @@
+package fproto
+
+import "iter"
+
+// Returns the messages inside the element, recursively, not counting extend
+// blocks and the element itself. Like CollectMessages, but lazy. Only the
+// messages matching all the filters are returned.
+func AllMessages(element FProtoElement, filters ...func(*MessageElement) bool) iter.Seq[*MessageElement] {
+	return allOf(element, func(m *MessageElement) bool { return !m.IsExtend }, filters)
+}
+
+// Returns the fields of the messages inside the element, recursively,
+// including oneofs and their fields, and map fields. Fields of extend blocks
+// are not returned. Like CollectFields, but lazy.
+func AllFields(element FProtoElement, filters ...func(FieldElementTag) bool) iter.Seq[FieldElementTag] {
+	return allOf(element, func(f FieldElementTag) bool {
+		m, ok := f.ParentElement().(*MessageElement)
+		return !ok || !m.IsExtend
+	}, filters)
+}
+
+// Returns the enums inside the element, recursively. Like CollectEnums, but lazy.
+func AllEnums(element FProtoElement, filters ...func(*EnumElement) bool) iter.Seq[*EnumElement] {
+	return allOf(element, nil, filters)
+}
+
+// Returns the services inside the element.
+func AllServices(element FProtoElement, filters ...func(*ServiceElement) bool) iter.Seq[*ServiceElement] {
+	return allOf(element, nil, filters)
+}
+
+// Returns the RPCs of all the services inside the element.
+func AllRPCs(element FProtoElement, filters ...func(*RPCElement) bool) iter.Seq[*RPCElement] {
+	return allOf(element, nil, filters)
+}
+
+// Returns the options of the element and of all the elements inside it.
+func AllOptions(element FProtoElement, filters ...func(*OptionElement) bool) iter.Seq[*OptionElement] {
+	return allOf(element, nil, filters)
+}
+
+// Returns the items of the sequence matching the predicate.
+func Filter[T any](seq iter.Seq[T], pred func(T) bool) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for item := range seq {
+			if pred(item) && !yield(item) {
+				return
+			}
+		}
+	}
+}
+
+// Yields the elements with type T inside the element, in Walk order.
+func allOf[T FProtoElement](element FProtoElement, match func(T) bool, filters []func(T) bool) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		inspectSeq(element, func(el FProtoElement) bool {
+			if el == element {
+				return true
+			}
+			t, ok := el.(T)
+			if !ok || (match != nil && !match(t)) {
+				return true
+			}
+			for _, filter := range filters {
+				if !filter(t) {
+					return true
+				}
+			}
+			return yield(t)
+		})
+	}
+}
+
+// Calls f for the element and all the elements inside it, in Walk order,
+// until f returns false. Returns false if stopped.
+func inspectSeq(element FProtoElement, f func(FProtoElement) bool) bool {
+	return f(element) && eachChild(element, func(child FProtoElement) bool {
+		return inspectSeq(child, f)
+	})
+}
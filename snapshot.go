@@ -0,0 +1,577 @@
+package fproto
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Current snapshot format version. Snapshots with other versions are stale.
+const SnapshotVersion = 2
+
+const snapshotMagic = "FPROTOSNAP"
+
+// Snapshots are gob encoded mirrors of the elements without Parent pointers,
+// gzip compressed. Parents are restored on load.
+
+type snapHeader struct {
+	Magic   string
+	Version int
+	// Files loaded explicitly, in order. Empty for WriteSnapshot.
+	Roots []string
+}
+
+type snapFile struct {
+	Path     string
+	Location string
+	Hash     string
+	Data     []byte
+
+	PackageName        string
+	Syntax             string
+	Dependencies       []string
+	PublicDependencies []string
+	WeakDependencies   []string
+	Options            []*snapOption
+	Enums              []*snapEnum
+	Messages           []*snapMessage
+	ExtendMessages     []*snapMessage
+	Services           []*snapService
+}
+
+type snapOption struct {
+	Name              string
+	ParenthesizedName string
+	NPName            string
+	IsParenthesized   bool
+	Value             *Literal
+	AggregatedValues  map[string]*Literal
+	Comment           *Comment
+}
+
+type snapEnumConstant struct {
+	Name    string
+	Comment *Comment
+	Options []*snapOption
+	Tag     int
+}
+
+type snapEnum struct {
+	Name          string
+	Comment       *Comment
+	Options       []*snapOption
+	EnumConstants []*snapEnumConstant
+}
+
+type snapRPC struct {
+	Name            string
+	Comment         *Comment
+	Options         []*snapOption
+	RequestType     string
+	StreamsRequest  bool
+	ResponseType    string
+	StreamsResponse bool
+}
+
+type snapService struct {
+	Name    string
+	Comment *Comment
+	Options []*snapOption
+	RPCs    []*snapRPC
+}
+
+const (
+	snapFieldNormal = iota
+	snapFieldMap
+	snapFieldOneOf
+)
+
+type snapField struct {
+	Kind     int
+	Name     string
+	Comment  *Comment
+	Options  []*snapOption
+	Repeated bool
+	Optional bool
+	Required bool
+	Type     string
+	Tag      int
+	KeyType  string
+	Fields   []*snapField
+}
+
+type snapRange struct {
+	Comment *Comment
+	Start   int
+	End     int
+	IsMax   bool
+}
+
+type snapMessage struct {
+	Name           string
+	Comment        *Comment
+	IsExtend       bool
+	Options        []*snapOption
+	Fields         []*snapField
+	Enums          []*snapEnum
+	Messages       []*snapMessage
+	ExtendMessages []*snapMessage
+	Extensions     []*snapRange
+	ReservedRanges []*snapRange
+	ReservedNames  []string
+}
+
+// Writes a snapshot of the files. The FileName of the files is kept.
+func WriteSnapshot(w io.Writer, files ...*ProtoFile) error {
+	var sfiles []*snapFile
+	for _, f := range files {
+		sfiles = append(sfiles, snapFromFile(f))
+	}
+	return writeSnapshot(w, nil, sfiles)
+}
+
+// Reads the files of a snapshot, with all Parent pointers restored. If the
+// snapshot format version is not the current one, a *SnapshotStale error is returned.
+func ReadSnapshot(r io.Reader) ([]*ProtoFile, error) {
+	_, sfiles, err := readSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+	var ret []*ProtoFile
+	for _, sf := range sfiles {
+		ret = append(ret, sf.toFile())
+	}
+	return ret, nil
+}
+
+// Saves a snapshot of the loaded files to a file, including their source
+// contents and hashes, so it can be checked for staleness later.
+func (s *FileSet) SaveSnapshot(fn string) error {
+	var sfiles []*snapFile
+	for _, f := range s.Files {
+		sf := snapFromFile(f)
+		sf.Location = s.Location(f.FileName)
+		sf.Hash = s.Hash(f.FileName)
+		sf.Data = s.Content(f.FileName)
+		sfiles = append(sfiles, sf)
+	}
+
+	var buf bytes.Buffer
+	if err := writeSnapshot(&buf, s.roots, sfiles); err != nil {
+		return err
+	}
+	// write atomically, so readers never see a partial snapshot
+	return writeFileAtomic(fn, buf.Bytes())
+}
+
+// Loads the files from a snapshot saved by SaveSnapshot, replacing all loaded
+// files. The files loaded explicitly are the same as when saved. If the
+// snapshot format version is not the current one, or if the source of any
+// file doesn't have the same content hash anymore, a *SnapshotStale error is
+// returned and the FileSet is not changed.
+func (s *FileSet) LoadSnapshot(fn string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	roots, sfiles, err := readSnapshot(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+
+	for _, sf := range sfiles {
+		_, data, err := s.readImport(sf.Path)
+		if err != nil {
+			if IsImportNotFound(err) {
+				return &SnapshotStale{Reason: fmt.Sprintf("file '%s' not found", sf.Path)}
+			}
+			return err
+		}
+		if ContentHash(data) != sf.Hash {
+			return &SnapshotStale{Reason: fmt.Sprintf("file '%s' changed", sf.Path)}
+		}
+	}
+
+	s.files = make(map[string]*fileSetEntry)
+	s.loading = make(map[string]bool)
+	s.Files = nil
+	s.symbols = nil
+	s.roots = nil
+	for _, sf := range sfiles {
+		pfile := sf.toFile()
+		s.files[sf.Path] = &fileSetEntry{
+			file:     pfile,
+			location: sf.Location,
+			data:     sf.Data,
+		}
+		s.Files = append(s.Files, pfile)
+	}
+	s.roots = roots
+	if len(s.roots) == 0 {
+		// written by WriteSnapshot, all files are roots
+		for _, sf := range sfiles {
+			s.roots = append(s.roots, sf.Path)
+		}
+	}
+	return nil
+}
+
+// Loads the files from the snapshot file if it is up to date, or else loads
+// them from the sources and saves a new snapshot. Returns whether the
+// snapshot was used.
+func (s *FileSet) LoadWithSnapshot(fn string, paths ...string) (bool, error) {
+	err := s.LoadSnapshot(fn)
+	if err == nil {
+		// the snapshot may have been saved from other paths
+		all := true
+		for _, path := range paths {
+			if s.FindFile(path) == nil {
+				all = false
+			}
+		}
+		if all {
+			return true, nil
+		}
+	} else if _, isstale := err.(*SnapshotStale); !isstale && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	s.files, s.Files, s.symbols, s.roots = nil, nil, nil, nil
+	if err := s.Load(paths...); err != nil {
+		return false, err
+	}
+	return false, s.SaveSnapshot(fn)
+}
+
+func writeSnapshot(w io.Writer, roots []string, sfiles []*snapFile) error {
+	zw := gzip.NewWriter(w)
+	enc := gob.NewEncoder(zw)
+	if err := enc.Encode(&snapHeader{Magic: snapshotMagic, Version: SnapshotVersion, Roots: roots}); err != nil {
+		return err
+	}
+	if err := enc.Encode(sfiles); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Returns the roots and the files of the snapshot.
+func readSnapshot(r io.Reader) ([]string, []*snapFile, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, &SnapshotStale{Reason: "invalid snapshot"}
+	}
+	defer zr.Close()
+
+	dec := gob.NewDecoder(zr)
+	header := &snapHeader{}
+	if err := dec.Decode(header); err != nil || header.Magic != snapshotMagic {
+		return nil, nil, &SnapshotStale{Reason: "invalid snapshot"}
+	}
+	if header.Version != SnapshotVersion {
+		return nil, nil, &SnapshotStale{Reason: fmt.Sprintf("snapshot version %d, current is %d", header.Version, SnapshotVersion)}
+	}
+
+	var sfiles []*snapFile
+	if err := dec.Decode(&sfiles); err != nil {
+		return nil, nil, &SnapshotStale{Reason: "invalid snapshot"}
+	}
+	return header.Roots, sfiles, nil
+}
+
+//
+// Elements to snapshot
+//
+
+func snapFromFile(f *ProtoFile) *snapFile {
+	ret := &snapFile{
+		Path:               f.FileName,
+		PackageName:        f.PackageName,
+		Syntax:             f.Syntax,
+		Dependencies:       f.Dependencies,
+		PublicDependencies: f.PublicDependencies,
+		WeakDependencies:   f.WeakDependencies,
+		Options:            snapFromOptions(f.Options),
+		Messages:           snapFromMessages(f.Messages),
+		ExtendMessages:     snapFromMessages(f.ExtendMessages),
+	}
+	for _, el := range f.Enums {
+		ret.Enums = append(ret.Enums, snapFromEnum(el))
+	}
+	for _, el := range f.Services {
+		srv := &snapService{
+			Name:    el.Name,
+			Comment: el.Comment,
+			Options: snapFromOptions(el.Options),
+		}
+		for _, rpc := range el.RPCs {
+			srv.RPCs = append(srv.RPCs, &snapRPC{
+				Name:            rpc.Name,
+				Comment:         rpc.Comment,
+				Options:         snapFromOptions(rpc.Options),
+				RequestType:     rpc.RequestType,
+				StreamsRequest:  rpc.StreamsRequest,
+				ResponseType:    rpc.ResponseType,
+				StreamsResponse: rpc.StreamsResponse,
+			})
+		}
+		ret.Services = append(ret.Services, srv)
+	}
+	return ret
+}
+
+func snapFromOptions(options []*OptionElement) []*snapOption {
+	var ret []*snapOption
+	for _, o := range options {
+		ret = append(ret, &snapOption{
+			Name:              o.Name,
+			ParenthesizedName: o.ParenthesizedName,
+			NPName:            o.NPName,
+			IsParenthesized:   o.IsParenthesized,
+			Value:             o.Value,
+			AggregatedValues:  o.AggregatedValues,
+			Comment:           o.Comment,
+		})
+	}
+	return ret
+}
+
+func snapFromEnum(e *EnumElement) *snapEnum {
+	ret := &snapEnum{
+		Name:    e.Name,
+		Comment: e.Comment,
+		Options: snapFromOptions(e.Options),
+	}
+	for _, c := range e.EnumConstants {
+		ret.EnumConstants = append(ret.EnumConstants, &snapEnumConstant{
+			Name:    c.Name,
+			Comment: c.Comment,
+			Options: snapFromOptions(c.Options),
+			Tag:     c.Tag,
+		})
+	}
+	return ret
+}
+
+func snapFromMessages(messages []*MessageElement) []*snapMessage {
+	var ret []*snapMessage
+	for _, m := range messages {
+		sm := &snapMessage{
+			Name:           m.Name,
+			Comment:        m.Comment,
+			IsExtend:       m.IsExtend,
+			Options:        snapFromOptions(m.Options),
+			Fields:         snapFromFields(m.Fields),
+			Messages:       snapFromMessages(m.Messages),
+			ExtendMessages: snapFromMessages(m.ExtendMessages),
+			ReservedNames:  m.ReservedNames,
+		}
+		for _, el := range m.Enums {
+			sm.Enums = append(sm.Enums, snapFromEnum(el))
+		}
+		for _, el := range m.Extensions {
+			sm.Extensions = append(sm.Extensions, &snapRange{Comment: el.Comment, Start: el.Start, End: el.End, IsMax: el.IsMax})
+		}
+		for _, el := range m.ReservedRanges {
+			sm.ReservedRanges = append(sm.ReservedRanges, &snapRange{Comment: el.Comment, Start: el.Start, End: el.End, IsMax: el.IsMax})
+		}
+		ret = append(ret, sm)
+	}
+	return ret
+}
+
+func snapFromFields(fields []FieldElementTag) []*snapField {
+	var ret []*snapField
+	for _, fld := range fields {
+		switch xfld := fld.(type) {
+		case *FieldElement:
+			ret = append(ret, snapFromField(xfld, snapFieldNormal))
+		case *MapFieldElement:
+			sf := snapFromField(xfld.FieldElement, snapFieldMap)
+			sf.KeyType = xfld.KeyType
+			ret = append(ret, sf)
+		case *OneOfFieldElement:
+			ret = append(ret, &snapField{
+				Kind:    snapFieldOneOf,
+				Name:    xfld.Name,
+				Comment: xfld.Comment,
+				Options: snapFromOptions(xfld.Options),
+				Fields:  snapFromFields(xfld.Fields),
+			})
+		}
+	}
+	return ret
+}
+
+func snapFromField(f *FieldElement, kind int) *snapField {
+	return &snapField{
+		Kind:     kind,
+		Name:     f.Name,
+		Comment:  f.Comment,
+		Options:  snapFromOptions(f.Options),
+		Repeated: f.Repeated,
+		Optional: f.Optional,
+		Required: f.Required,
+		Type:     f.Type,
+		Tag:      f.Tag,
+	}
+}
+
+//
+// Snapshot to elements
+//
+
+func (sf *snapFile) toFile() *ProtoFile {
+	ret := &ProtoFile{
+		FileName:           sf.Path,
+		PackageName:        sf.PackageName,
+		Syntax:             sf.Syntax,
+		Dependencies:       sf.Dependencies,
+		PublicDependencies: sf.PublicDependencies,
+		WeakDependencies:   sf.WeakDependencies,
+	}
+	ret.Options = snapToOptions(sf.Options, ret)
+	ret.Messages = snapToMessages(sf.Messages, ret)
+	ret.ExtendMessages = snapToMessages(sf.ExtendMessages, ret)
+	for _, el := range sf.Enums {
+		ret.Enums = append(ret.Enums, el.toEnum(ret))
+	}
+	for _, el := range sf.Services {
+		srv := &ServiceElement{
+			Parent:  ret,
+			Name:    el.Name,
+			Comment: el.Comment,
+		}
+		srv.Options = snapToOptions(el.Options, srv)
+		for _, rpc := range el.RPCs {
+			r := &RPCElement{
+				Parent:          srv,
+				Name:            rpc.Name,
+				Comment:         rpc.Comment,
+				RequestType:     rpc.RequestType,
+				StreamsRequest:  rpc.StreamsRequest,
+				ResponseType:    rpc.ResponseType,
+				StreamsResponse: rpc.StreamsResponse,
+			}
+			r.Options = snapToOptions(rpc.Options, r)
+			srv.RPCs = append(srv.RPCs, r)
+		}
+		ret.Services = append(ret.Services, srv)
+	}
+	return ret
+}
+
+func snapToOptions(options []*snapOption, parent FProtoElement) []*OptionElement {
+	var ret []*OptionElement
+	for _, o := range options {
+		ret = append(ret, &OptionElement{
+			Parent:            parent,
+			Name:              o.Name,
+			ParenthesizedName: o.ParenthesizedName,
+			NPName:            o.NPName,
+			IsParenthesized:   o.IsParenthesized,
+			Value:             o.Value,
+			AggregatedValues:  o.AggregatedValues,
+			Comment:           o.Comment,
+		})
+	}
+	return ret
+}
+
+func (se *snapEnum) toEnum(parent FProtoElement) *EnumElement {
+	ret := &EnumElement{
+		Parent:  parent,
+		Name:    se.Name,
+		Comment: se.Comment,
+	}
+	ret.Options = snapToOptions(se.Options, ret)
+	for _, c := range se.EnumConstants {
+		ec := &EnumConstantElement{
+			Parent:  ret,
+			Name:    c.Name,
+			Comment: c.Comment,
+			Tag:     c.Tag,
+		}
+		ec.Options = snapToOptions(c.Options, ec)
+		ret.EnumConstants = append(ret.EnumConstants, ec)
+	}
+	return ret
+}
+
+func snapToMessages(messages []*snapMessage, parent FProtoElement) []*MessageElement {
+	var ret []*MessageElement
+	for _, sm := range messages {
+		m := &MessageElement{
+			Parent:        parent,
+			Name:          sm.Name,
+			Comment:       sm.Comment,
+			IsExtend:      sm.IsExtend,
+			ReservedNames: sm.ReservedNames,
+		}
+		m.Options = snapToOptions(sm.Options, m)
+		m.Fields = snapToFields(sm.Fields, m)
+		m.Messages = snapToMessages(sm.Messages, m)
+		m.ExtendMessages = snapToMessages(sm.ExtendMessages, m)
+		for _, el := range sm.Enums {
+			m.Enums = append(m.Enums, el.toEnum(m))
+		}
+		for _, el := range sm.Extensions {
+			m.Extensions = append(m.Extensions, &ExtensionsElement{Parent: m, Comment: el.Comment, Start: el.Start, End: el.End, IsMax: el.IsMax})
+		}
+		for _, el := range sm.ReservedRanges {
+			m.ReservedRanges = append(m.ReservedRanges, &ReservedRangeElement{Parent: m, Comment: el.Comment, Start: el.Start, End: el.End, IsMax: el.IsMax})
+		}
+		ret = append(ret, m)
+	}
+	return ret
+}
+
+func snapToFields(fields []*snapField, parent FProtoElement) []FieldElementTag {
+	var ret []FieldElementTag
+	for _, sf := range fields {
+		switch sf.Kind {
+		case snapFieldNormal:
+			ret = append(ret, sf.toField(parent))
+		case snapFieldMap:
+			// like the parser, the inner field has the same parent as the map field
+			mf := &MapFieldElement{
+				Parent:       parent,
+				FieldElement: sf.toField(parent),
+				KeyType:      sf.KeyType,
+			}
+			mf.Options = snapToOptions(sf.Options, mf)
+			ret = append(ret, mf)
+		case snapFieldOneOf:
+			oo := &OneOfFieldElement{
+				Parent:  parent,
+				Name:    sf.Name,
+				Comment: sf.Comment,
+			}
+			oo.Options = snapToOptions(sf.Options, oo)
+			oo.Fields = snapToFields(sf.Fields, oo)
+			ret = append(ret, oo)
+		}
+	}
+	return ret
+}
+
+func (sf *snapField) toField(parent FProtoElement) *FieldElement {
+	ret := &FieldElement{
+		Parent:   parent,
+		Name:     sf.Name,
+		Comment:  sf.Comment,
+		Repeated: sf.Repeated,
+		Optional: sf.Optional,
+		Required: sf.Required,
+		Type:     sf.Type,
+		Tag:      sf.Tag,
+	}
+	ret.Options = snapToOptions(sf.Options, ret)
+	return ret
+}
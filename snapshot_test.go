@@ -0,0 +1,98 @@
+package fproto
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	source := MapImportSource{}
+	for k, v := range testlinkset {
+		source[k] = v
+	}
+	fn := filepath.Join(t.TempDir(), "schema.snap")
+
+	fs := NewFileSet(source)
+	used, err := fs.LoadWithSnapshot(fn, "user/user.proto")
+	if err != nil {
+		t.Fatalf("Error loading proto files: %v", err)
+	}
+	if used {
+		t.Fatalf("Snapshot should not exist yet")
+	}
+	if st, err := os.Stat(fn); err != nil || st.Mode().Perm() != 0644 {
+		t.Fatalf("Snapshot should be saved with mode 0644")
+	}
+
+	fs2 := NewFileSet(source)
+	used, err = fs2.LoadWithSnapshot(fn, "user/user.proto")
+	if err != nil {
+		t.Fatalf("Error loading snapshot: %v", err)
+	}
+	if !used {
+		t.Fatalf("Snapshot should be used")
+	}
+
+	if len(fs2.Files) != len(fs.Files) {
+		t.Fatalf("Expected %d files, got %d", len(fs.Files), len(fs2.Files))
+	}
+	for i, f := range fs2.Files {
+		if !reflect.DeepEqual(snapFromFile(f), snapFromFile(fs.Files[i])) {
+			t.Fatalf("File '%s' differs from the snapshot", f.FileName)
+		}
+	}
+
+	address := fs2.FindFile("user/user.proto").FindName("User.Address")[0].(*MessageElement)
+	if FullName(address.Fields[1]) != "p_user.User.Address.kind" {
+		t.Fatalf("Parent pointers not restored, got name '%s'", FullName(address.Fields[1]))
+	}
+	if _, err := Link(fs2); len(err.(*UnresolvedTypes).Refs) != 1 {
+		t.Fatalf("Snapshot files should link like the parsed ones, got %v", err)
+	}
+
+	// only the files loaded explicitly are roots, so unloading removes the imports
+	if !reflect.DeepEqual(fs2.roots, []string{"user/user.proto"}) {
+		t.Fatalf("Snapshot roots not restored: %v", fs2.roots)
+	}
+	fs3 := NewFileSet(source)
+	if err := fs3.LoadSnapshot(fn); err != nil {
+		t.Fatalf("Error loading snapshot: %v", err)
+	}
+	if err := fs3.Unload("user/user.proto"); err != nil || len(fs3.Files) != 0 {
+		t.Fatalf("Unloading the root should remove its imports, got %d files", len(fs3.Files))
+	}
+
+	// changing a source makes the snapshot stale
+	source["internal/secret.proto"] = `syntax = "proto3"; package internal; message Secret { int32 id = 1; }`
+	if err := NewFileSet(source).LoadSnapshot(fn); err == nil {
+		t.Fatalf("Snapshot should be stale")
+	} else if _, ok := err.(*SnapshotStale); !ok {
+		t.Fatalf("Expected *SnapshotStale error, got %v", err)
+	}
+
+	// a snapshot without the files is invalid
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := gob.NewEncoder(zw).Encode(&snapHeader{Magic: snapshotMagic, Version: SnapshotVersion}); err != nil {
+		t.Fatal(err)
+	}
+	zw.Close()
+	if _, err := ReadSnapshot(&buf); err == nil {
+		t.Fatalf("Truncated snapshot should be invalid")
+	} else if _, ok := err.(*SnapshotStale); !ok {
+		t.Fatalf("Expected *SnapshotStale error, got %v", err)
+	}
+
+	fs4 := NewFileSet(source)
+	if used, err := fs4.LoadWithSnapshot(fn, "user/user.proto"); err != nil || used {
+		t.Fatalf("Stale snapshot should be rebuilt, got %v", err)
+	}
+	if len(fs4.FindFile("internal/secret.proto").Messages[0].Fields) != 1 {
+		t.Fatalf("Rebuilt files should have the new contents")
+	}
+}
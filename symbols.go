@@ -0,0 +1,255 @@
+package fproto
+
+import (
+	"sort"
+	"strings"
+)
+
+// SymbolTable indexes elements by fully qualified name, including the package.
+//
+// Messages, enums, enum constants, services, rpcs, fields, oneofs and
+// extension fields are indexed. Each package name part is also a symbol,
+// with the declaring ProtoFile as element.
+type SymbolTable struct {
+	symbols map[string][]*Symbol
+	names   []string // sorted, built on demand
+}
+
+// Symbol is an element indexed by its fully qualified name, without the leading dot.
+type Symbol struct {
+	Name    string
+	Element FProtoElement
+	File    *ProtoFile
+}
+
+// Returns whether the symbol is a package name.
+func (s *Symbol) IsPackage() bool {
+	_, ok := s.Element.(*ProtoFile)
+	return ok
+}
+
+// Creates an empty symbol table.
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{
+		symbols: make(map[string][]*Symbol),
+	}
+}
+
+// Creates a symbol table with all the symbols of the files.
+func BuildSymbolTable(files ...*ProtoFile) *SymbolTable {
+	t := NewSymbolTable()
+	for _, f := range files {
+		t.AddFile(f)
+	}
+	return t
+}
+
+// Returns the first symbol with the name. A leading dot is allowed.
+// Package symbols are only returned if no other element has the same name.
+func (t *SymbolTable) Lookup(name string) *Symbol {
+	var pkg *Symbol
+	for _, s := range t.LookupAll(name) {
+		if !s.IsPackage() {
+			return s
+		}
+		if pkg == nil {
+			pkg = s
+		}
+	}
+	return pkg
+}
+
+// Returns all symbols with the name, in the order they were added. More than
+// one is returned for packages declared in many files and for duplicate definitions.
+func (t *SymbolTable) LookupAll(name string) []*Symbol {
+	return t.symbols[strings.TrimPrefix(name, ".")]
+}
+
+// Returns the symbol and all the symbols declared inside it, sorted by name.
+// Ex: LookupPrefix("p_user.User") returns the User message and all its fields, nested messages, etc.
+func (t *SymbolTable) LookupPrefix(prefix string) []*Symbol {
+	prefix = strings.TrimPrefix(prefix, ".")
+	names := t.sortedNames()
+
+	var ret []*Symbol
+	for i := sort.SearchStrings(names, prefix); i < len(names) && strings.HasPrefix(names[i], prefix); i++ {
+		if prefix == "" || len(names[i]) == len(prefix) || names[i][len(prefix)] == '.' {
+			ret = append(ret, t.symbols[names[i]]...)
+		}
+	}
+	return ret
+}
+
+// Returns all the non-package symbols declared in files of the package, sorted by name.
+func (t *SymbolTable) Package(pkg string) []*Symbol {
+	var ret []*Symbol
+	for _, name := range t.sortedNames() {
+		for _, s := range t.symbols[name] {
+			if !s.IsPackage() && s.File.PackageName == pkg {
+				ret = append(ret, s)
+			}
+		}
+	}
+	return ret
+}
+
+// Returns the names of all packages, sorted.
+func (t *SymbolTable) Packages() []string {
+	seen := make(map[string]bool)
+	var ret []string
+	for _, name := range t.sortedNames() {
+		for _, s := range t.symbols[name] {
+			if s.IsPackage() && s.File.PackageName == name && !seen[name] {
+				seen[name] = true
+				ret = append(ret, name)
+			}
+		}
+	}
+	return ret
+}
+
+// Adds all the symbols of the file.
+func (t *SymbolTable) AddFile(f *ProtoFile) {
+	// all package parts are symbols
+	if f.PackageName != "" {
+		parts := strings.Split(f.PackageName, ".")
+		for i := range parts {
+			t.add(strings.Join(parts[:i+1], "."), f, f)
+		}
+	}
+
+	for _, el := range f.Messages {
+		t.addMessage(el, f)
+	}
+	for _, el := range f.Enums {
+		t.addEnum(el, f)
+	}
+	for _, el := range f.ExtendMessages {
+		t.addExtend(el, f)
+	}
+	for _, el := range f.Services {
+		t.add(FullName(el), el, f)
+		for _, rpc := range el.RPCs {
+			t.add(FullName(rpc), rpc, f)
+		}
+	}
+}
+
+func (t *SymbolTable) add(name string, element FProtoElement, file *ProtoFile) {
+	if _, ok := t.symbols[name]; !ok {
+		t.names = nil
+	}
+	t.symbols[name] = append(t.symbols[name], &Symbol{Name: name, Element: element, File: file})
+}
+
+func (t *SymbolTable) addMessage(m *MessageElement, f *ProtoFile) {
+	t.add(FullName(m), m, f)
+
+	for _, fld := range m.Fields {
+		t.add(FullName(fld), fld, f)
+		if oo, ok := fld.(*OneOfFieldElement); ok {
+			for _, ofld := range oo.Fields {
+				t.add(FullName(ofld), ofld, f)
+			}
+		}
+	}
+	for _, el := range m.Messages {
+		t.addMessage(el, f)
+	}
+	for _, el := range m.Enums {
+		t.addEnum(el, f)
+	}
+	for _, el := range m.ExtendMessages {
+		t.addExtend(el, f)
+	}
+}
+
+func (t *SymbolTable) addEnum(e *EnumElement, f *ProtoFile) {
+	t.add(FullName(e), e, f)
+	for _, c := range e.EnumConstants {
+		t.add(FullName(c), c, f)
+	}
+}
+
+func (t *SymbolTable) addExtend(m *MessageElement, f *ProtoFile) {
+	for _, fld := range m.Fields {
+		t.add(FullName(fld), fld, f)
+	}
+}
+
+func (t *SymbolTable) sortedNames() []string {
+	if t.names == nil {
+		t.names = make([]string, 0, len(t.symbols))
+		for name := range t.symbols {
+			t.names = append(t.names, name)
+		}
+		sort.Strings(t.names)
+	}
+	return t.names
+}
+
+// Returns the fully qualified name of the element, including the package,
+// without the leading dot. Ex: "p_user.User.Address".
+//
+// Enum constants are named in the scope of their enum, like protobuf does.
+// Extend blocks and extension/reserved ranges have the name of their scope,
+// and options the name of their element followed by the option name.
+func FullName(element FProtoElement) string {
+	switch el := element.(type) {
+	case nil:
+		return ""
+	case *ProtoFile:
+		return el.PackageName
+	case *MessageElement:
+		if el.IsExtend {
+			// extend blocks don't declare a name
+			return scopeName(el.Parent)
+		}
+		return joinName(scopeName(el.Parent), el.Name)
+	case *EnumConstantElement:
+		if el.Parent != nil {
+			return joinName(scopeName(el.Parent.ParentElement()), el.Name)
+		}
+		return el.Name
+	case *OptionElement:
+		return joinName(FullName(el.Parent), el.Name)
+	case *ExtensionsElement, *ReservedRangeElement:
+		return FullName(el.ParentElement())
+	}
+	return joinName(scopeName(element.ParentElement()), element.ElementName())
+}
+
+// Returns the name scope the element children are declared in.
+func scopeName(element FProtoElement) string {
+	switch el := element.(type) {
+	case nil:
+		return ""
+	case *MessageElement:
+		if el.IsExtend {
+			return scopeName(el.Parent)
+		}
+	case *OneOfFieldElement:
+		// oneof fields belong to the message
+		return scopeName(el.Parent)
+	}
+	return FullName(element)
+}
+
+func joinName(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	if name == "" {
+		return scope
+	}
+	return scope + "." + name
+}
+
+// Returns the symbol table of all the loaded files. It is built on the first
+// call, and rebuilt after more files are loaded.
+func (s *FileSet) Symbols() *SymbolTable {
+	if s.symbols == nil {
+		s.symbols = BuildSymbolTable(s.Files...)
+	}
+	return s.symbols
+}
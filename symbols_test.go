@@ -0,0 +1,56 @@
+package fproto
+
+import (
+	"testing"
+)
+
+func TestSymbolTable(t *testing.T) {
+	fs := NewFileSet(testlinkset)
+	if err := fs.Load("user/user.proto"); err != nil {
+		t.Fatalf("Error loading proto file: %v", err)
+	}
+
+	symbols := fs.Symbols()
+
+	for name, tp := range map[string]string{
+		"p_user.User.Address":          "MESSAGE",
+		".p_user.User.Address.kind":    "FIELD",
+		"p_user.User.WORK":             "ENUM CONSTANT",
+		"p_user.User.contact":          "ONEOF FIELD",
+		"p_user.User.secret":           "FIELD",
+		"p_user.User.addresses":        "MAP FIELD",
+		"p_user.UserService.GetUser":   "RPC",
+		"p_user.sensitive":             "FIELD",
+		"google.protobuf.Timestamp":    "",
+		"google.protobuf.FieldOptions": "MESSAGE",
+	} {
+		s := symbols.Lookup(name)
+		if tp == "" {
+			if s != nil {
+				t.Fatalf("Symbol '%s' should not exist", name)
+			}
+			continue
+		}
+		if s == nil {
+			t.Fatalf("Symbol '%s' not found", name)
+		}
+		if s.Element.ElementTypeName() != tp {
+			t.Fatalf("Symbol '%s' should be a %s, but is %s", name, tp, s.Element.ElementTypeName())
+		}
+		if fn := FullName(s.Element); "."+fn != name && fn != name {
+			t.Fatalf("FullName of symbol '%s' returned '%s'", name, fn)
+		}
+	}
+
+	if pkg := symbols.Lookup("google"); pkg == nil || !pkg.IsPackage() {
+		t.Fatalf("Package part 'google' should be a package symbol")
+	}
+
+	if n := len(symbols.LookupPrefix("p_user.User.Address")); n != 3 {
+		t.Fatalf("Expected 3 symbols with prefix p_user.User.Address, got %d", n)
+	}
+
+	if n := len(symbols.Package("common")); n != 3 {
+		t.Fatalf("Expected 3 symbols in package common, got %d", n)
+	}
+}
@@ -1,8 +1,61 @@
 package fproto
 
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
 func ReverseStr(s []string) []string {
 	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
 		s[i], s[j] = s[j], s[i]
 	}
 	return s
 }
+
+func containsStr(list []string, s string) bool {
+	for _, ls := range list {
+		if ls == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	var ret []string
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func sortFilesByName(files []*ProtoFile) {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].FileName < files[j].FileName
+	})
+}
+
+// Writes the file using a temporary file in the same directory, so readers
+// never see a partial file.
+func writeFileAtomic(fn string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(fn), filepath.Base(fn)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fn)
+}
@@ -0,0 +1,275 @@
+package fproto
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Current version of the lockfile format
+const DepLockVersion = 1
+
+// Name of the lockfile written by Vendor in the vendor directory
+const DepLockName = "fproto.lock"
+
+// DepManifest lists the local directories proto dependencies are loaded from.
+//
+// It is stored as JSON:
+//
+//	{"deps": [{"name": "googleapis", "dir": "third_party/googleapis"}]}
+type DepManifest struct {
+	Deps []*DepModule `json:"deps"`
+}
+
+// DepModule is a named directory of proto files.
+type DepModule struct {
+	Name string `json:"name"`
+	Dir  string `json:"dir"`
+}
+
+// DepLock records the content hash of every vendored file.
+type DepLock struct {
+	Version int            `json:"version"`
+	Files   []*DepLockFile `json:"files"`
+}
+
+// DepLockFile is a file locked by import path. Module is empty for files not
+// coming from a manifest dependency.
+type DepLockFile struct {
+	Path   string `json:"path"`
+	Module string `json:"module,omitempty"`
+	Hash   string `json:"hash"`
+}
+
+// Reads a manifest file. Relative directories are relative to the manifest file.
+func ReadDepManifest(fn string) (*DepManifest, error) {
+	m := &DepManifest{}
+	if err := readJSONFile(fn, m); err != nil {
+		return nil, err
+	}
+	for _, d := range m.Deps {
+		if !filepath.IsAbs(d.Dir) {
+			d.Dir = filepath.Join(filepath.Dir(fn), filepath.FromSlash(d.Dir))
+		}
+	}
+	return m, nil
+}
+
+// Returns an include path source searching the dependency directories in order.
+func (m *DepManifest) ImportSource() *IncludePathSource {
+	var dirs []string
+	for _, d := range m.Deps {
+		dirs = append(dirs, d.Dir)
+	}
+	return NewIncludePathSource(dirs...)
+}
+
+// Returns the dependency which contains the file location, or nil.
+func (m *DepManifest) FindModule(location string) *DepModule {
+	for _, d := range m.Deps {
+		if rel, err := filepath.Rel(d.Dir, location); err == nil && !strings.HasPrefix(rel, "..") {
+			return d
+		}
+	}
+	return nil
+}
+
+// Reads a lockfile.
+func ReadDepLock(fn string) (*DepLock, error) {
+	l := &DepLock{}
+	if err := readJSONFile(fn, l); err != nil {
+		return nil, err
+	}
+	if l.Version != DepLockVersion {
+		return nil, &LockVersionMismatch{Version: l.Version}
+	}
+	return l, nil
+}
+
+// Writes the lockfile.
+func (l *DepLock) WriteFile(fn string) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fn, append(data, '\n'), 0644)
+}
+
+// Finds a locked file by import path.
+func (l *DepLock) FindFile(path string) *DepLockFile {
+	for _, f := range l.Files {
+		if f.Path == path {
+			return f
+		}
+	}
+	return nil
+}
+
+// Copies all the loaded files except the builtin ones into dir, by import
+// path, and writes the lock of the copied files to DepLockName in dir.
+//
+// Files of the previous lock in dir not part of the FileSet anymore are
+// removed. Other files are never removed: if dir has no lock and contains
+// proto files not part of the FileSet, a *VendorDirNotManaged error is
+// returned and nothing is changed.
+//
+// The manifest is used to fill the module names, and can be nil.
+func (s *FileSet) Vendor(dir string, manifest *DepManifest) (*DepLock, error) {
+	lock := &DepLock{
+		Version: DepLockVersion,
+	}
+
+	for _, pfile := range s.Files {
+		location := s.Location(pfile.FileName)
+		if strings.HasPrefix(location, "builtin:") {
+			continue
+		}
+
+		// the file is written by import path, it must not escape dir
+		if !IsValidImportPath(pfile.FileName) {
+			return nil, &InvalidImportPath{Path: pfile.FileName}
+		}
+
+		lf := &DepLockFile{
+			Path: pfile.FileName,
+			Hash: s.Hash(pfile.FileName),
+		}
+		if manifest != nil {
+			if d := manifest.FindModule(location); d != nil {
+				lf.Module = d.Name
+			}
+		}
+		lock.Files = append(lock.Files, lf)
+	}
+
+	sort.Slice(lock.Files, func(i, j int) bool {
+		return lock.Files[i].Path < lock.Files[j].Path
+	})
+
+	lockFile := filepath.Join(dir, DepLockName)
+	prev, err := ReadDepLock(lockFile)
+	if os.IsNotExist(err) {
+		prev = nil
+		if err := checkVendorDir(dir, lock); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, lf := range lock.Files {
+		fn := filepath.Join(dir, filepath.FromSlash(lf.Path))
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(fn, s.Content(lf.Path), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	// remove stale files, only the ones vendored before
+	if prev != nil {
+		for _, lf := range prev.Files {
+			if lock.FindFile(lf.Path) != nil {
+				continue
+			}
+			if !IsValidImportPath(lf.Path) {
+				return nil, &InvalidImportPath{Path: lf.Path}
+			}
+			fn := filepath.Join(dir, filepath.FromSlash(lf.Path))
+			if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			removeEmptyDirs(dir, filepath.Dir(fn))
+		}
+	}
+
+	if err := lock.WriteFile(lockFile); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// Returns an error if the directory has proto files that are not in the lock.
+func checkVendorDir(dir string, lock *DepLock) error {
+	var unknown []string
+	err := filepath.Walk(dir, func(fn string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && fn == dir {
+			return filepath.SkipDir
+		}
+		if err != nil || info.IsDir() || !strings.HasSuffix(fn, ".proto") {
+			return err
+		}
+		rel, err := filepath.Rel(dir, fn)
+		if err != nil {
+			return err
+		}
+		if lock.FindFile(filepath.ToSlash(rel)) == nil {
+			unknown = append(unknown, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(unknown) > 0 {
+		return &VendorDirNotManaged{Dir: dir, Files: unknown}
+	}
+	return nil
+}
+
+// VendorImportSource reads files from a vendor directory, checking their
+// contents against the lock. Files not in the lock are not found.
+type VendorImportSource struct {
+	Dir  string
+	Lock *DepLock
+}
+
+// Creates a vendor import source, with the lock read from lockFile. If
+// lockFile is empty, the lock written by Vendor in dir is used.
+func NewVendorImportSource(dir, lockFile string) (*VendorImportSource, error) {
+	if lockFile == "" {
+		lockFile = filepath.Join(dir, DepLockName)
+	}
+	lock, err := ReadDepLock(lockFile)
+	if err != nil {
+		return nil, err
+	}
+	return &VendorImportSource{
+		Dir:  dir,
+		Lock: lock,
+	}, nil
+}
+
+func (s *VendorImportSource) OpenImport(path string) (io.ReadCloser, string, error) {
+	lf := s.Lock.FindFile(path)
+	if lf == nil {
+		return nil, "", &ImportNotFound{Path: path}
+	}
+
+	fn := filepath.Join(s.Dir, filepath.FromSlash(path))
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, "", &HashMismatch{Path: path, Location: fn, Expected: lf.Hash}
+		}
+		return nil, "", err
+	}
+	if hash := ContentHash(data); hash != lf.Hash {
+		return nil, "", &HashMismatch{Path: path, Location: fn, Expected: lf.Hash, Actual: hash}
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), fn, nil
+}
+
+func readJSONFile(fn string, v interface{}) error {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
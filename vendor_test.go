@@ -0,0 +1,140 @@
+package fproto
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testvendorset = MapImportSource{
+	"app/user.proto": `
+syntax = "proto3";
+package app;
+import "app/money.proto";
+import "google/protobuf/timestamp.proto";
+message User {
+	Money balance = 1;
+	google.protobuf.Timestamp created = 2;
+}
+`,
+	"app/money.proto": `
+syntax = "proto3";
+package app;
+message Money {
+	int64 units = 1;
+}
+`,
+	"app/other.proto": `
+syntax = "proto3";
+package app;
+message Other {}
+`,
+}
+
+func TestVendor(t *testing.T) {
+	dir := t.TempDir()
+	mine := filepath.Join(dir, "mine.proto")
+	if err := ioutil.WriteFile(mine, []byte(`syntax = "proto3";`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := NewFileSet(testvendorset)
+	if err := fs.Load("app/user.proto", "app/other.proto"); err != nil {
+		t.Fatalf("Error loading proto files: %v", err)
+	}
+
+	// proto files not vendored before, and no lock
+	if _, err := fs.Vendor(dir, nil); err == nil {
+		t.Fatalf("Vendoring to a directory with unknown proto files should fail")
+	} else if _, ok := err.(*VendorDirNotManaged); !ok {
+		t.Fatalf("Wrong error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app", "user.proto")); !os.IsNotExist(err) {
+		t.Fatalf("Nothing should be written when vendoring fails")
+	}
+
+	dir = filepath.Join(t.TempDir(), "vendor")
+	lock, err := fs.Vendor(dir, nil)
+	if err != nil {
+		t.Fatalf("Error vendoring: %v", err)
+	}
+	if len(lock.Files) != 3 || lock.FindFile("google/protobuf/timestamp.proto") != nil {
+		t.Fatalf("Builtin files should not be vendored: %v", lock.Files)
+	}
+
+	// lock round trip
+	readLock, err := ReadDepLock(filepath.Join(dir, DepLockName))
+	if err != nil {
+		t.Fatalf("Error reading lock: %v", err)
+	}
+	if len(readLock.Files) != 3 || readLock.FindFile("app/user.proto").Hash != fs.Hash("app/user.proto") {
+		t.Fatalf("Lock not read back equal")
+	}
+
+	// files not vendored by fproto are kept, stale vendored files are removed
+	mine = filepath.Join(dir, "app", "mine.proto")
+	if err := ioutil.WriteFile(mine, []byte(`syntax = "proto3";`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Unload("app/other.proto"); err != nil {
+		t.Fatalf("Error unloading: %v", err)
+	}
+	if _, err := fs.Vendor(dir, nil); err != nil {
+		t.Fatalf("Error vendoring again: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app", "other.proto")); !os.IsNotExist(err) {
+		t.Fatalf("Stale vendored file should be removed")
+	}
+	if _, err := os.Stat(mine); err != nil {
+		t.Fatalf("Files not vendored by fproto should not be removed")
+	}
+
+	// load from the vendor directory
+	vs, err := NewVendorImportSource(dir, "")
+	if err != nil {
+		t.Fatalf("Error creating vendor source: %v", err)
+	}
+	vfs := NewFileSet(vs)
+	if err := vfs.Load("app/user.proto"); err != nil {
+		t.Fatalf("Error loading from vendor directory: %v", err)
+	}
+	if _, err := vfs.LoadFile("app/mine.proto"); !IsImportNotFound(err) {
+		t.Fatalf("Files not in the lock should not be found: %v", err)
+	}
+
+	// changed and missing files
+	if err := ioutil.WriteFile(filepath.Join(dir, "app", "money.proto"), []byte(`syntax = "proto3";`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = vs.OpenImport("app/money.proto")
+	if hm, ok := err.(*HashMismatch); !ok || hm.Actual == "" || hm.Expected != lock.FindFile("app/money.proto").Hash {
+		t.Fatalf("Changed file should be a hash mismatch: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "app", "money.proto")); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = vs.OpenImport("app/money.proto")
+	if hm, ok := err.(*HashMismatch); !ok || hm.Actual != "" {
+		t.Fatalf("Missing file should be a hash mismatch: %v", err)
+	}
+}
+
+func TestVendorInvalidPath(t *testing.T) {
+	root := t.TempDir()
+	fs := NewFileSet(MapImportSource{
+		"../evil.proto": `syntax = "proto3";`,
+	})
+	if err := fs.Load("../evil.proto"); err != nil {
+		t.Fatalf("Error loading proto files: %v", err)
+	}
+
+	if _, err := fs.Vendor(filepath.Join(root, "vendor"), nil); err == nil {
+		t.Fatalf("Vendoring a file outside the directory should fail")
+	} else if _, ok := err.(*InvalidImportPath); !ok {
+		t.Fatalf("Expected an InvalidImportPath error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "evil.proto")); !os.IsNotExist(err) {
+		t.Fatalf("Files outside the vendor directory should not be written")
+	}
+}
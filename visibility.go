@@ -0,0 +1,43 @@
+package fproto
+
+// Returns the files whose definitions are visible from the file, following
+// the protoc rules: the file itself, the files it imports directly, and the
+// files reexported by those with "import public", transitively.
+//
+// Files imported by an imported file without "public" are not visible.
+// The file itself is returned first, then the others in import order.
+func (s *FileSet) VisibleFiles(f *ProtoFile) []*ProtoFile {
+	ret := []*ProtoFile{f}
+	seen := map[*ProtoFile]bool{f: true}
+
+	var addPublic func(dep *ProtoFile)
+	addPublic = func(dep *ProtoFile) {
+		if dep == nil || seen[dep] {
+			return
+		}
+		seen[dep] = true
+		ret = append(ret, dep)
+		for _, pub := range dep.PublicDependencies {
+			addPublic(s.FindFile(pub))
+		}
+	}
+
+	for _, dep := range f.Dependencies {
+		addPublic(s.FindFile(dep))
+	}
+	for _, dep := range f.WeakDependencies {
+		addPublic(s.FindFile(dep))
+	}
+
+	return ret
+}
+
+// Returns whether the definitions of the file "to" are visible from the file "from".
+func (s *FileSet) IsVisible(from, to *ProtoFile) bool {
+	for _, f := range s.VisibleFiles(from) {
+		if f == to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package fproto
+
+// A Visitor's Visit method is called for each element found by Walk. If the
+// returned visitor w is not nil, Walk visits each child of the element with
+// w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(element FProtoElement) (w Visitor)
+}
+
+// Walks the element tree in depth-first order, like go/ast.Walk: it calls
+// v.Visit(element), and if the returned visitor is not nil, walks each child
+// of the element with it, then calls w.Visit(nil) on leaving the element.
+//
+// Children are visited in this order:
+//   - ProtoFile: options, enums, messages, extend blocks, services
+//   - MessageElement: options, fields, enums, messages, extend blocks,
+//     extension ranges, reserved ranges
+//   - OneOfFieldElement: options, fields
+//   - EnumElement: options, enum constants
+//   - ServiceElement: options, RPCs
+//   - FieldElement, MapFieldElement, EnumConstantElement, RPCElement: options
+func Walk(element FProtoElement, v Visitor) {
+	if v = v.Visit(element); v == nil {
+		return
+	}
+	for _, l := range elementChildLists(element) {
+		for i, n := 0, l.len(element); i < n; i++ {
+			Walk(l.at(element, i), v)
+		}
+	}
+	v.Visit(nil)
+}
+
+// A list of children of an element type, with typed accessors.
+type childList struct {
+	// Name of the list field, like "Fields"
+	name string
+	len  func(element FProtoElement) int
+	at   func(element FProtoElement, i int) FProtoElement
+}
+
+func childListOf[E, C FProtoElement](name string, list func(E) []C) childList {
+	return childList{
+		name: name,
+		len: func(element FProtoElement) int {
+			return len(list(element.(E)))
+		},
+		at: func(element FProtoElement, i int) FProtoElement {
+			return list(element.(E))[i]
+		},
+	}
+}
+
+// Child lists of each element type, in traversal order. Walk, Apply, the
+// navigation helpers and the iterators all traverse the children using them.
+var (
+	fileChildLists = []childList{
+		childListOf("Options", func(e *ProtoFile) []*OptionElement { return e.Options }),
+		childListOf("Enums", func(e *ProtoFile) []*EnumElement { return e.Enums }),
+		childListOf("Messages", func(e *ProtoFile) []*MessageElement { return e.Messages }),
+		childListOf("ExtendMessages", func(e *ProtoFile) []*MessageElement { return e.ExtendMessages }),
+		childListOf("Services", func(e *ProtoFile) []*ServiceElement { return e.Services }),
+	}
+	messageChildLists = []childList{
+		childListOf("Options", func(e *MessageElement) []*OptionElement { return e.Options }),
+		childListOf("Fields", func(e *MessageElement) []FieldElementTag { return e.Fields }),
+		childListOf("Enums", func(e *MessageElement) []*EnumElement { return e.Enums }),
+		childListOf("Messages", func(e *MessageElement) []*MessageElement { return e.Messages }),
+		childListOf("ExtendMessages", func(e *MessageElement) []*MessageElement { return e.ExtendMessages }),
+		childListOf("Extensions", func(e *MessageElement) []*ExtensionsElement { return e.Extensions }),
+		childListOf("ReservedRanges", func(e *MessageElement) []*ReservedRangeElement { return e.ReservedRanges }),
+	}
+	fieldChildLists = []childList{
+		childListOf("Options", func(e *FieldElement) []*OptionElement { return e.Options }),
+	}
+	// the embedded FieldElement is not a separate element
+	mapFieldChildLists = []childList{
+		childListOf("Options", func(e *MapFieldElement) []*OptionElement { return e.FieldElement.Options }),
+	}
+	oneOfChildLists = []childList{
+		childListOf("Options", func(e *OneOfFieldElement) []*OptionElement { return e.Options }),
+		childListOf("Fields", func(e *OneOfFieldElement) []FieldElementTag { return e.Fields }),
+	}
+	enumChildLists = []childList{
+		childListOf("Options", func(e *EnumElement) []*OptionElement { return e.Options }),
+		childListOf("EnumConstants", func(e *EnumElement) []*EnumConstantElement { return e.EnumConstants }),
+	}
+	enumConstantChildLists = []childList{
+		childListOf("Options", func(e *EnumConstantElement) []*OptionElement { return e.Options }),
+	}
+	serviceChildLists = []childList{
+		childListOf("Options", func(e *ServiceElement) []*OptionElement { return e.Options }),
+		childListOf("RPCs", func(e *ServiceElement) []*RPCElement { return e.RPCs }),
+	}
+	rpcChildLists = []childList{
+		childListOf("Options", func(e *RPCElement) []*OptionElement { return e.Options }),
+	}
+)
+
+func elementChildLists(element FProtoElement) []childList {
+	switch element.(type) {
+	case *ProtoFile:
+		return fileChildLists
+	case *MessageElement:
+		return messageChildLists
+	case *FieldElement:
+		return fieldChildLists
+	case *MapFieldElement:
+		return mapFieldChildLists
+	case *OneOfFieldElement:
+		return oneOfChildLists
+	case *EnumElement:
+		return enumChildLists
+	case *EnumConstantElement:
+		return enumConstantChildLists
+	case *ServiceElement:
+		return serviceChildLists
+	case *RPCElement:
+		return rpcChildLists
+	}
+	return nil
+}
+
+// Calls f for each child of the element, in traversal order, until f returns
+// false. Returns false if stopped.
+func eachChild(element FProtoElement, f func(FProtoElement) bool) bool {
+	for _, l := range elementChildLists(element) {
+		for i, n := 0, l.len(element); i < n; i++ {
+			if !f(l.at(element, i)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+type inspector func(FProtoElement) bool
+
+func (f inspector) Visit(element FProtoElement) Visitor {
+	if f(element) {
+		return f
+	}
+	return nil
+}
+
+// Traverses the element tree in depth-first order, like go/ast.Inspect: it
+// calls f(element), and if it returns true, inspects each child of the
+// element, followed by a call of f(nil).
+func Inspect(element FProtoElement, f func(FProtoElement) bool) {
+	Walk(element, inspector(f))
+}
@@ -0,0 +1,361 @@
+package fproto
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+type WatchEventKind int
+
+const (
+	FileAddedEvent WatchEventKind = iota + 1
+	FileRemovedEvent
+	MessageAddedEvent
+	MessageRemovedEvent
+	MessageChangedEvent
+	FieldChangedEvent
+	ImportChangedEvent
+	WatchErrorEvent
+)
+
+func (k WatchEventKind) String() string {
+	switch k {
+	case FileAddedEvent:
+		return "file added"
+	case FileRemovedEvent:
+		return "file removed"
+	case MessageAddedEvent:
+		return "message added"
+	case MessageRemovedEvent:
+		return "message removed"
+	case MessageChangedEvent:
+		return "message changed"
+	case FieldChangedEvent:
+		return "field changed"
+	case ImportChangedEvent:
+		return "import changed"
+	case WatchErrorEvent:
+		return "error"
+	}
+	return "unknown"
+}
+
+// WatchEvent is a change detected by a Watcher.
+type WatchEvent struct {
+	Kind WatchEventKind
+	// Import path of the file
+	File string
+	// Full name of the message or field, or the import path added or removed for imports
+	Name string
+	// Element before and after the change. Old is nil when added, New is nil when removed.
+	Old FProtoElement
+	New FProtoElement
+	// Error for WatchErrorEvent
+	Err error
+}
+
+func (e *WatchEvent) String() string {
+	if e.Kind == WatchErrorEvent {
+		return fmt.Sprintf("%s: %s", e.Kind, e.Err.Error())
+	}
+	if e.Name == "" {
+		return fmt.Sprintf("%s: %s", e.Kind, e.File)
+	}
+	return fmt.Sprintf("%s: %s (%s)", e.Kind, e.Name, e.File)
+}
+
+// Watcher polls the sources of the FileSet files, reloads the changed ones,
+// and sends events describing the changes between the old and new elements.
+//
+// Polling is used so no platform specific APIs are needed.
+//
+// Poll and Run change the FileSet and the Links, so they must not be used by
+// other goroutines while the watcher runs. Use the elements of the events, or
+// stop the watcher before reading them.
+type Watcher struct {
+	FileSet  *FileSet
+	Interval time.Duration
+
+	// Links to update after files change. Optional.
+	Links *Links
+
+	// Returns the files that should be loaded, to detect new and removed
+	// files, like BufWorkspace.Files. Optional.
+	Discover func() ([]string, error)
+
+	once   sync.Once
+	events chan *WatchEvent
+}
+
+// Creates a watcher polling the FileSet sources at every interval.
+func NewWatcher(fs *FileSet, interval time.Duration) *Watcher {
+	return &Watcher{
+		FileSet:  fs,
+		Interval: interval,
+	}
+}
+
+// Returns the channel where events are sent. It is closed when Run returns.
+func (w *Watcher) Events() <-chan *WatchEvent {
+	return w.eventsChan()
+}
+
+func (w *Watcher) eventsChan() chan *WatchEvent {
+	w.once.Do(func() {
+		w.events = make(chan *WatchEvent, 100)
+	})
+	return w.events
+}
+
+// Polls for changes until the context is cancelled. Errors loading files are
+// sent as WatchErrorEvent, and the files are retried at the next poll.
+// The Interval must be positive.
+func (w *Watcher) Run(ctx context.Context) error {
+	events := w.eventsChan()
+	defer close(events)
+
+	if w.Interval <= 0 {
+		return fmt.Errorf("Invalid watch interval %s", w.Interval)
+	}
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		for _, ev := range w.Poll() {
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// Checks the sources once, reloading the changed files, and returns the
+// change events. Run calls it at every interval. Errors loading files are
+// returned as WatchErrorEvent after the events of the changes that could be
+// applied.
+func (w *Watcher) Poll() []*WatchEvent {
+	fs := w.FileSet
+
+	old := make(map[string]*ProtoFile)
+	for _, f := range fs.Files {
+		old[f.FileName] = f
+	}
+
+	var changed, removed []string
+	for _, f := range fs.Files {
+		_, data, err := fs.readImport(f.FileName)
+		if err != nil {
+			if IsImportNotFound(err) {
+				removed = append(removed, f.FileName)
+				continue
+			}
+			return []*WatchEvent{{Kind: WatchErrorEvent, File: f.FileName, Err: err}}
+		}
+		if ContentHash(data) != fs.Hash(f.FileName) {
+			changed = append(changed, f.FileName)
+		}
+	}
+
+	var added []string
+	if w.Discover != nil {
+		paths, err := w.Discover()
+		if err != nil {
+			return []*WatchEvent{{Kind: WatchErrorEvent, Err: err}}
+		}
+		current := make(map[string]bool)
+		for _, path := range paths {
+			current[path] = true
+			if old[path] == nil {
+				added = append(added, path)
+			}
+		}
+		for _, root := range fs.roots {
+			if !current[root] && old[root] != nil && !containsStr(removed, root) {
+				removed = append(removed, root)
+			}
+		}
+	}
+
+	if len(changed) == 0 && len(removed) == 0 && len(added) == 0 {
+		return nil
+	}
+
+	// files are reloaded and loaded one at a time, so a file failing to load
+	// doesn't hold back the other changes; it is retried at the next poll
+	var errs []*WatchEvent
+	if len(removed) > 0 {
+		if err := fs.Unload(removed...); err != nil {
+			errs = append(errs, &WatchEvent{Kind: WatchErrorEvent, Err: err})
+		}
+	}
+	for _, path := range changed {
+		// files no longer imported after the removals are not reloaded
+		if fs.FindFile(path) == nil {
+			continue
+		}
+		if _, err := fs.Reload(path); err != nil {
+			errs = append(errs, &WatchEvent{Kind: WatchErrorEvent, File: path, Err: err})
+		}
+	}
+	for _, path := range added {
+		if err := fs.Load(path); err != nil {
+			errs = append(errs, &WatchEvent{Kind: WatchErrorEvent, File: path, Err: err})
+		}
+	}
+
+	if w.Links != nil {
+		// relink everything that may reference a changed file
+		var relink []*ProtoFile
+		for _, f := range fs.Files {
+			if old[f.FileName] != f || w.dependsOnChanged(f, old) {
+				relink = append(relink, f)
+			}
+		}
+		w.Links.Relink(relink...)
+	}
+
+	return append(DiffFileSets(old, fs.Files), errs...)
+}
+
+func (w *Watcher) dependsOnChanged(f *ProtoFile, old map[string]*ProtoFile) bool {
+	for _, dep := range w.FileSet.importsOf(f) {
+		if d := w.FileSet.FindFile(dep); d != nil && (old[dep] != d || w.dependsOnChanged(d, old)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the events describing the changes from the old files, by import
+// path, to the new ones. Files with the same pointer are considered unchanged.
+func DiffFileSets(old map[string]*ProtoFile, files []*ProtoFile) []*WatchEvent {
+	var ret []*WatchEvent
+
+	current := make(map[string]bool)
+	for _, f := range files {
+		current[f.FileName] = true
+		o, ok := old[f.FileName]
+		if !ok {
+			ret = append(ret, &WatchEvent{Kind: FileAddedEvent, File: f.FileName, New: f})
+			continue
+		}
+		if o != f {
+			ret = append(ret, DiffFiles(o, f)...)
+		}
+	}
+
+	// removed files, in a stable order
+	var removed []*ProtoFile
+	for _, o := range old {
+		if !current[o.FileName] {
+			removed = append(removed, o)
+		}
+	}
+	sortFilesByName(removed)
+	for _, o := range removed {
+		ret = append(ret, &WatchEvent{Kind: FileRemovedEvent, File: o.FileName, Old: o})
+	}
+
+	return ret
+}
+
+// Returns the events describing the changes between two versions of a file.
+func DiffFiles(old, new *ProtoFile) []*WatchEvent {
+	var ret []*WatchEvent
+
+	// imports
+	oldImports, newImports := fileImports(old), fileImports(new)
+	for _, imp := range sortedKeys(newImports) {
+		if oldImports[imp] != newImports[imp] {
+			ret = append(ret, &WatchEvent{Kind: ImportChangedEvent, File: new.FileName, Name: imp})
+		}
+	}
+	for _, imp := range sortedKeys(oldImports) {
+		if _, ok := newImports[imp]; !ok {
+			ret = append(ret, &WatchEvent{Kind: ImportChangedEvent, File: new.FileName, Name: imp})
+		}
+	}
+
+	// messages, by full name
+	oldMessages := make(map[string]*MessageElement)
+	for _, el := range old.CollectMessages() {
+		oldMessages[FullName(el)] = el.(*MessageElement)
+	}
+	newNames := make(map[string]bool)
+	for _, el := range new.CollectMessages() {
+		m := el.(*MessageElement)
+		name := FullName(m)
+		newNames[name] = true
+
+		om, ok := oldMessages[name]
+		if !ok {
+			ret = append(ret, &WatchEvent{Kind: MessageAddedEvent, File: new.FileName, Name: name, New: m})
+			continue
+		}
+
+		fieldEvents := diffFields(new.FileName, om, m)
+		if len(fieldEvents) > 0 || !reflect.DeepEqual(snapMessageShallow(om), snapMessageShallow(m)) {
+			ret = append(ret, &WatchEvent{Kind: MessageChangedEvent, File: new.FileName, Name: name, Old: om, New: m})
+		}
+		ret = append(ret, fieldEvents...)
+	}
+	for _, el := range old.CollectMessages() {
+		if name := FullName(el); !newNames[name] {
+			ret = append(ret, &WatchEvent{Kind: MessageRemovedEvent, File: old.FileName, Name: name, Old: el})
+		}
+	}
+
+	return ret
+}
+
+func diffFields(file string, old, new *MessageElement) []*WatchEvent {
+	var ret []*WatchEvent
+	for _, fld := range new.Fields {
+		ofld := old.FindField(fld.FieldName())
+		if ofld == nil {
+			ret = append(ret, &WatchEvent{Kind: FieldChangedEvent, File: file, Name: FullName(fld), New: fld})
+		} else if !reflect.DeepEqual(snapFromFields([]FieldElementTag{ofld}), snapFromFields([]FieldElementTag{fld})) {
+			ret = append(ret, &WatchEvent{Kind: FieldChangedEvent, File: file, Name: FullName(fld), Old: ofld, New: fld})
+		}
+	}
+	for _, ofld := range old.Fields {
+		if new.FindField(ofld.FieldName()) == nil {
+			ret = append(ret, &WatchEvent{Kind: FieldChangedEvent, File: file, Name: FullName(ofld), Old: ofld})
+		}
+	}
+	return ret
+}
+
+// The message without the nested messages, which are compared separately.
+func snapMessageShallow(m *MessageElement) *snapMessage {
+	ret := snapFromMessages([]*MessageElement{m})[0]
+	ret.Messages = nil
+	return ret
+}
+
+// Import path to kind
+func fileImports(f *ProtoFile) map[string]string {
+	ret := make(map[string]string)
+	for _, dep := range f.Dependencies {
+		ret[dep] = "import"
+	}
+	for _, dep := range f.PublicDependencies {
+		ret[dep] = "public"
+	}
+	for _, dep := range f.WeakDependencies {
+		ret[dep] = "weak"
+	}
+	return ret
+}
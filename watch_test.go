@@ -0,0 +1,187 @@
+package fproto
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDiffFiles(t *testing.T) {
+	old, err := Parse(strings.NewReader(`
+syntax = "proto3";
+package shop;
+import "common/money.proto";
+import "common/time.proto";
+
+message Product {
+	string id = 1;
+	string name = 2;
+	int32 stock = 3;
+}
+
+message Category {
+	string id = 1;
+}
+`))
+	if err != nil {
+		t.Fatalf("Error parsing proto file: %v", err)
+	}
+	new, err := Parse(strings.NewReader(`
+syntax = "proto3";
+package shop;
+import public "common/money.proto";
+import "common/tags.proto";
+
+message Product {
+	string id = 1;
+	string title = 2;
+	int64 stock = 3;
+}
+
+message Order {
+	string id = 1;
+}
+`))
+	if err != nil {
+		t.Fatalf("Error parsing proto file: %v", err)
+	}
+	old.FileName, new.FileName = "shop/shop.proto", "shop/shop.proto"
+
+	var events []string
+	for _, ev := range DiffFiles(old, new) {
+		events = append(events, ev.String())
+	}
+	expected := []string{
+		"import changed: common/money.proto (shop/shop.proto)",
+		"import changed: common/tags.proto (shop/shop.proto)",
+		"import changed: common/time.proto (shop/shop.proto)",
+		"message changed: shop.Product (shop/shop.proto)",
+		"field changed: shop.Product.title (shop/shop.proto)",
+		"field changed: shop.Product.stock (shop/shop.proto)",
+		"field changed: shop.Product.name (shop/shop.proto)",
+		"message added: shop.Order (shop/shop.proto)",
+		"message removed: shop.Category (shop/shop.proto)",
+	}
+	if strings.Join(events, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Wrong events:\n%s", strings.Join(events, "\n"))
+	}
+}
+
+func TestWatcherPoll(t *testing.T) {
+	source := MapImportSource{
+		"shop/product.proto": `
+syntax = "proto3";
+package shop;
+import "shop/price.proto";
+message Product {
+	Price price = 1;
+}
+`,
+		"shop/price.proto": `
+syntax = "proto3";
+package shop;
+message Price {
+	int64 cents = 1;
+}
+`,
+	}
+	fs := NewFileSet(source)
+	if err := fs.Load("shop/product.proto"); err != nil {
+		t.Fatalf("Error loading proto files: %v", err)
+	}
+	links, err := Link(fs)
+	if err != nil {
+		t.Fatalf("Error linking: %v", err)
+	}
+
+	w := &Watcher{FileSet: fs, Links: links}
+	if events := w.Poll(); len(events) != 0 {
+		t.Fatalf("No events expected without changes, got %v", events)
+	}
+
+	// the import is replaced by a new file
+	source["shop/product.proto"] = `
+syntax = "proto3";
+package shop;
+import "shop/money.proto";
+message Product {
+	Money price = 1;
+}
+`
+	source["shop/money.proto"] = `
+syntax = "proto3";
+package shop;
+message Money {
+	int64 units = 1;
+}
+`
+	var events []string
+	for _, ev := range w.Poll() {
+		events = append(events, ev.String())
+	}
+	expected := []string{
+		"file added: shop/money.proto",
+		"import changed: shop/money.proto (shop/product.proto)",
+		"import changed: shop/price.proto (shop/product.proto)",
+		"message changed: shop.Product (shop/product.proto)",
+		"field changed: shop.Product.price (shop/product.proto)",
+		"file removed: shop/price.proto",
+	}
+	if strings.Join(events, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Wrong events:\n%s", strings.Join(events, "\n"))
+	}
+
+	product := fs.FindFile("shop/product.proto").Messages[0]
+	money, ok := links.FieldType(product.FindField("price").(*FieldElement)).(*MessageElement)
+	if !ok || money.Name != "Money" {
+		t.Fatalf("Links should be updated after the poll")
+	}
+
+	// parse errors are reported, and the files are kept
+	source["shop/money.proto"] = "message {"
+	if events := w.Poll(); len(events) != 1 || events[0].Kind != WatchErrorEvent {
+		t.Fatalf("Expected an error event, got %v", events)
+	}
+	if fs.FindFile("shop/money.proto") == nil {
+		t.Fatalf("Files should be kept after an error")
+	}
+
+	// a file failing to load doesn't hold back the changes of the others
+	source["shop/money.proto"] = `
+syntax = "proto3";
+package shop;
+import "shop/missing.proto";
+message Money {
+	int64 units = 1;
+}
+`
+	source["shop/product.proto"] += `
+message Extra {
+}
+`
+	events = nil
+	for _, ev := range w.Poll() {
+		events = append(events, ev.String())
+	}
+	expected = []string{
+		"message added: shop.Extra (shop/product.proto)",
+		"error: Import 'shop/missing.proto' not found",
+	}
+	if strings.Join(events, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Wrong events:\n%s", strings.Join(events, "\n"))
+	}
+	if events := w.Poll(); len(events) != 1 || events[0].Kind != WatchErrorEvent {
+		t.Fatalf("Only the failing file should be retried, got %v", events)
+	}
+}
+
+func TestWatcherRun(t *testing.T) {
+	// zero value watcher, with an invalid interval
+	w := &Watcher{FileSet: NewFileSet()}
+	if err := w.Run(context.Background()); err == nil {
+		t.Fatalf("Run should fail with an invalid interval")
+	}
+	if _, ok := <-w.Events(); ok {
+		t.Fatalf("Events channel should be closed when Run returns")
+	}
+}
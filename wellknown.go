@@ -0,0 +1,51 @@
+package fproto
+
+import (
+	"embed"
+	"io"
+	"io/fs"
+	"sort"
+)
+
+// Standard google/protobuf sources, as shipped with protoc.
+//
+//go:embed wellknown
+var wellknownFS embed.FS
+
+type builtinImportSource struct{}
+
+// BuiltinImportSource returns an import source containing the standard
+// google/protobuf files (timestamp.proto, any.proto, descriptor.proto, etc),
+// so they can be resolved without a protoc install.
+//
+// FileSet always searches this source after all the configured ones.
+func BuiltinImportSource() ImportSource {
+	return builtinImportSource{}
+}
+
+func (s builtinImportSource) OpenImport(path string) (io.ReadCloser, string, error) {
+	f, err := wellknownFS.Open("wellknown/" + path)
+	if err != nil {
+		return nil, "", &ImportNotFound{Path: path}
+	}
+	return f, "builtin:" + path, nil
+}
+
+// Returns whether the import path is one of the builtin google/protobuf files.
+func IsBuiltinImport(path string) bool {
+	st, err := fs.Stat(wellknownFS, "wellknown/"+path)
+	return err == nil && !st.IsDir()
+}
+
+// Returns the import paths of all builtin google/protobuf files, sorted.
+func BuiltinImports() []string {
+	var ret []string
+	fs.WalkDir(wellknownFS, "wellknown", func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			ret = append(ret, path[len("wellknown/"):])
+		}
+		return nil
+	})
+	sort.Strings(ret)
+	return ret
+}